@@ -0,0 +1,308 @@
+// Минимальная реализация BGP-4 (RFC 4271) для анонса маршрутов IPv4 unicast.
+// Спикер только анонсирует и отзывает свои префиксы, маршруты соседей игнорируются.
+package bgp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/amkulikov/blocked_routes/routes"
+)
+
+// Типы сообщений
+const (
+	msgOpen         = 1
+	msgUpdate       = 2
+	msgNotification = 3
+	msgKeepalive    = 4
+)
+
+const (
+	headerLen     = 19
+	maxMessageLen = 4096
+	bgpVersion    = 4
+	asTrans       = 23456 // AS, подставляемый вместо 4-байтного номера (RFC 6793)
+)
+
+// Атрибуты пути
+const (
+	attrOrigin      = 1
+	attrASPath      = 2
+	attrNextHop     = 3
+	attrLocalPref   = 5
+	attrCommunities = 8
+	attrAS4Path     = 17
+
+	attrFlagOptional   = 0x80
+	attrFlagTransitive = 0x40
+	attrFlagExtended   = 0x10
+
+	asSequence = 2
+	originIGP  = 0
+)
+
+// Capabilities (RFC 5492)
+const (
+	optParamCapabilities = 2
+	capMultiprotocol     = 1
+	capFourOctetAS       = 65
+)
+
+// Коды ошибок NOTIFICATION
+const (
+	errHeader       = 1
+	errOpen         = 2
+	errUpdate       = 3
+	errHoldTimer    = 4
+	errFSM          = 5
+	errCease        = 6
+	errOpenBadPeer  = 2 // подкод errOpen: неверный AS соседа
+	errOpenHoldTime = 6 // подкод errOpen: недопустимое время удержания
+)
+
+// Ошибка сессии, о которой соседу отправляется NOTIFICATION
+type notificationError struct {
+	code, subcode byte
+	reason        string
+}
+
+func (e *notificationError) Error() string {
+	return fmt.Sprintf("%s (notification %d/%d)", e.reason, e.code, e.subcode)
+}
+
+// Ошибка, полученная от соседа в NOTIFICATION
+type peerNotificationError struct {
+	code, subcode byte
+}
+
+func (e *peerNotificationError) Error() string {
+	return fmt.Sprintf("peer sent notification %d/%d", e.code, e.subcode)
+}
+
+// Запись сообщения с заголовком
+func writeMessage(w io.Writer, typ byte, body []byte) error {
+	msg := make([]byte, headerLen, headerLen+len(body))
+	for i := 0; i < 16; i++ {
+		msg[i] = 0xff
+	}
+	binary.BigEndian.PutUint16(msg[16:], uint16(headerLen+len(body)))
+	msg[18] = typ
+	_, err := w.Write(append(msg, body...))
+	return err
+}
+
+// Чтение сообщения с проверкой заголовка
+func readMessage(r io.Reader) (typ byte, body []byte, err error) {
+	var header [headerLen]byte
+	if _, err = io.ReadFull(r, header[:]); err != nil {
+		return 0, nil, err
+	}
+	for _, b := range header[:16] {
+		if b != 0xff {
+			return 0, nil, &notificationError{errHeader, 1, "connection not synchronized"}
+		}
+	}
+	length := int(binary.BigEndian.Uint16(header[16:]))
+	if length < headerLen || length > maxMessageLen {
+		return 0, nil, &notificationError{errHeader, 2, fmt.Sprintf("bad message length %d", length)}
+	}
+	body = make([]byte, length-headerLen)
+	if _, err = io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+	return header[18], body, nil
+}
+
+// Сообщение OPEN
+type openMessage struct {
+	AS          uint32 // номер AS, 4-байтный при поддержке соседом
+	HoldTime    uint16
+	RouterID    uint32
+	FourOctetAS bool // поддержка 4-байтных номеров AS
+}
+
+func (o *openMessage) encode() []byte {
+	myAS := o.AS
+	if myAS > 0xffff {
+		myAS = asTrans
+	}
+	var caps []byte
+	caps = append(caps, capMultiprotocol, 4, 0, 1, 0, 1) // IPv4 unicast
+	caps = append(caps, capFourOctetAS, 4)
+	caps = appendUint32(caps, o.AS)
+
+	b := []byte{bgpVersion}
+	b = appendUint16(b, uint16(myAS))
+	b = appendUint16(b, o.HoldTime)
+	b = appendUint32(b, o.RouterID)
+	b = append(b, byte(2+len(caps)), optParamCapabilities, byte(len(caps)))
+	return append(b, caps...)
+}
+
+func decodeOpen(b []byte) (o openMessage, err error) {
+	if len(b) < 10 {
+		return o, &notificationError{errOpen, 0, "short OPEN message"}
+	}
+	if b[0] != bgpVersion {
+		return o, &notificationError{errOpen, 1, fmt.Sprintf("unsupported BGP version %d", b[0])}
+	}
+	o.AS = uint32(binary.BigEndian.Uint16(b[1:]))
+	o.HoldTime = binary.BigEndian.Uint16(b[3:])
+	o.RouterID = binary.BigEndian.Uint32(b[5:])
+	params := b[10:]
+	if int(b[9]) != len(params) {
+		return o, &notificationError{errOpen, 0, "bad optional parameters length"}
+	}
+	for len(params) >= 2 {
+		typ, length := params[0], int(params[1])
+		if len(params) < 2+length {
+			return o, &notificationError{errOpen, 0, "bad optional parameter"}
+		}
+		value := params[2 : 2+length]
+		params = params[2+length:]
+		if typ != optParamCapabilities {
+			continue
+		}
+		for len(value) >= 2 {
+			code, clen := value[0], int(value[1])
+			if len(value) < 2+clen {
+				return o, &notificationError{errOpen, 0, "bad capability"}
+			}
+			if code == capFourOctetAS && clen == 4 {
+				o.FourOctetAS = true
+				o.AS = binary.BigEndian.Uint32(value[2:])
+			}
+			value = value[2+clen:]
+		}
+	}
+	return o, nil
+}
+
+func encodeNotification(code, subcode byte) []byte {
+	return []byte{code, subcode}
+}
+
+// Атрибуты пути анонсируемых маршрутов
+type pathAttributes struct {
+	AS          uint32
+	IBGP        bool // сосед в той же AS: пустой AS_PATH и LOCAL_PREF
+	FourOctetAS bool // сосед поддерживает 4-байтные номера AS
+	NextHop     uint32
+	Communities []uint32
+}
+
+func appendAttr(b []byte, flags, typ byte, value []byte) []byte {
+	if len(value) > 0xff {
+		b = append(b, flags|attrFlagExtended, typ)
+		b = appendUint16(b, uint16(len(value)))
+	} else {
+		b = append(b, flags, typ, byte(len(value)))
+	}
+	return append(b, value...)
+}
+
+func (pa *pathAttributes) encode() []byte {
+	b := appendAttr(nil, attrFlagTransitive, attrOrigin, []byte{originIGP})
+
+	var path []byte
+	if !pa.IBGP {
+		path = []byte{asSequence, 1}
+		if pa.FourOctetAS {
+			path = appendUint32(path, pa.AS)
+		} else if pa.AS > 0xffff {
+			path = appendUint16(path, asTrans)
+		} else {
+			path = appendUint16(path, uint16(pa.AS))
+		}
+	}
+	b = appendAttr(b, attrFlagTransitive, attrASPath, path)
+	if !pa.IBGP && !pa.FourOctetAS && pa.AS > 0xffff {
+		// настоящий номер AS для соседей, не поддерживающих 4-байтные номера, передается в AS4_PATH
+		as4 := appendUint32([]byte{asSequence, 1}, pa.AS)
+		b = appendAttr(b, attrFlagOptional|attrFlagTransitive, attrAS4Path, as4)
+	}
+
+	b = appendAttr(b, attrFlagTransitive, attrNextHop, appendUint32(nil, pa.NextHop))
+	if pa.IBGP {
+		b = appendAttr(b, attrFlagTransitive, attrLocalPref, appendUint32(nil, 100))
+	}
+	if len(pa.Communities) > 0 {
+		var c []byte
+		for _, community := range pa.Communities {
+			c = appendUint32(c, community)
+		}
+		b = appendAttr(b, attrFlagOptional|attrFlagTransitive, attrCommunities, c)
+	}
+	return b
+}
+
+// Запись префикса в формате NLRI: длина маски и значимые байты адреса
+func appendPrefix(b []byte, p routes.Prefix) []byte {
+	b = append(b, p.Len)
+	var addr [4]byte
+	binary.BigEndian.PutUint32(addr[:], p.Addr)
+	return append(b, addr[:(p.Len+7)/8]...)
+}
+
+// Место под отзываемые префиксы, атрибуты пути и NLRI в сообщении UPDATE
+const updateSpace = maxMessageLen - headerLen - 4
+
+// Формирование сообщений UPDATE: отзыв withdrawn и анонс announced с атрибутами attrs.
+// Префиксы распределяются по сообщениям так, чтобы не превысить максимальный размер сообщения.
+// Если вместе с атрибутами в сообщение не помещается ни один префикс, возвращается ошибка.
+func encodeUpdates(withdrawn, announced []routes.Prefix, attrs []byte) (msgs [][]byte, err error) {
+	if len(announced) > 0 && len(attrs)+5 > updateSpace {
+		return nil, fmt.Errorf("path attributes of %d bytes don't fit into UPDATE message", len(attrs))
+	}
+
+	for len(withdrawn) > 0 {
+		var w []byte
+		for len(withdrawn) > 0 && len(w)+5 <= updateSpace {
+			w = appendPrefix(w, withdrawn[0])
+			withdrawn = withdrawn[1:]
+		}
+		msg := appendUint16(nil, uint16(len(w)))
+		msg = append(msg, w...)
+		msgs = append(msgs, appendUint16(msg, 0))
+	}
+
+	for len(announced) > 0 {
+		var nlri []byte
+		for len(announced) > 0 && len(attrs)+len(nlri)+5 <= updateSpace {
+			nlri = appendPrefix(nlri, announced[0])
+			announced = announced[1:]
+		}
+		msg := appendUint16(nil, 0)
+		msg = appendUint16(msg, uint16(len(attrs)))
+		msg = append(msg, attrs...)
+		msgs = append(msgs, append(msg, nlri...))
+	}
+	return msgs, nil
+}
+
+// Разбор BGP community в виде "asn:value"
+func ParseCommunity(s string) (uint32, error) {
+	parts := strings.Split(strings.TrimSpace(s), ":")
+	if len(parts) != 2 {
+		return 0, fmt.Errorf("invalid BGP community %q", s)
+	}
+	var values [2]uint64
+	for i, p := range parts {
+		var err error
+		if values[i], err = strconv.ParseUint(p, 10, 16); err != nil {
+			return 0, fmt.Errorf("invalid BGP community %q", s)
+		}
+	}
+	return uint32(values[0])<<16 | uint32(values[1]), nil
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
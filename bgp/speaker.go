@@ -0,0 +1,376 @@
+package bgp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/amkulikov/blocked_routes/routes"
+)
+
+// Параметры спикера
+type Config struct {
+	ASN          uint32        // собственный номер AS
+	PeerASN      uint32        // ожидаемый номер AS соседей, 0 - любой
+	RouterID     net.IP        // BGP identifier
+	NextHop      net.IP        // next hop анонсируемых маршрутов
+	Communities  []uint32      // BGP community анонсируемых маршрутов
+	HoldTime     time.Duration // предлагаемое время удержания, по умолчанию 90 секунд
+	ConnectRetry time.Duration // пауза перед повторным подключением, по умолчанию 10 секунд
+
+	Logf func(format string, v ...interface{}) // журнал событий сессий, может быть nil
+}
+
+// BGP-спикер, анонсирующий набор префиксов всем установленным сессиям
+type Speaker struct {
+	cfg      Config
+	routerID uint32
+	nextHop  uint32
+
+	mu       sync.Mutex
+	routes   map[routes.Prefix]struct{} // текущий анонсируемый набор
+	sessions map[*session]struct{}      // установленные сессии
+}
+
+// Создание спикера с проверкой параметров
+func NewSpeaker(cfg Config) (*Speaker, error) {
+	if cfg.ASN == 0 {
+		return nil, errors.New("ASN is required")
+	}
+	routerID := cfg.RouterID.To4()
+	if routerID == nil {
+		return nil, errors.New("IPv4 router ID is required")
+	}
+	nextHop := cfg.NextHop.To4()
+	if nextHop == nil {
+		return nil, errors.New("IPv4 next hop is required")
+	}
+	if cfg.HoldTime == 0 {
+		cfg.HoldTime = 90 * time.Second
+	}
+	if cfg.HoldTime < 3*time.Second || cfg.HoldTime > 0xffff*time.Second {
+		return nil, errors.New("hold time must be between 3 and 65535 seconds")
+	}
+	if cfg.ConnectRetry == 0 {
+		cfg.ConnectRetry = 10 * time.Second
+	}
+	// атрибуты пути наибольшего размера: для соседей без 4-байтных номеров AS, либо iBGP с LOCAL_PREF
+	for _, pa := range []*pathAttributes{{AS: cfg.ASN}, {AS: cfg.ASN, IBGP: true}} {
+		pa.Communities = cfg.Communities
+		if len(pa.encode())+5 > updateSpace {
+			return nil, fmt.Errorf("%d communities don't fit into UPDATE message", len(cfg.Communities))
+		}
+	}
+	return &Speaker{
+		cfg:      cfg,
+		routerID: binary.BigEndian.Uint32(routerID),
+		nextHop:  binary.BigEndian.Uint32(nextHop),
+		routes:   make(map[routes.Prefix]struct{}),
+		sessions: make(map[*session]struct{}),
+	}, nil
+}
+
+func (s *Speaker) logf(format string, v ...interface{}) {
+	if s.cfg.Logf != nil {
+		s.cfg.Logf(format, v...)
+	}
+}
+
+// Замена анонсируемого набора префиксов. Установленным сессиям отправляются только изменения:
+// отзыв исчезнувших префиксов и анонс новых.
+func (s *Speaker) SetRoutes(prefixes []routes.Prefix) (announced, withdrawn int) {
+	next := make(map[routes.Prefix]struct{}, len(prefixes))
+	for _, p := range prefixes {
+		next[p] = struct{}{}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var add, del []routes.Prefix
+	for p := range next {
+		if _, ok := s.routes[p]; !ok {
+			add = append(add, p)
+		}
+	}
+	for p := range s.routes {
+		if _, ok := next[p]; !ok {
+			del = append(del, p)
+		}
+	}
+	sortPrefixes(add)
+	sortPrefixes(del)
+	s.routes = next
+
+	// сообщения ставятся в очереди сессий и отправляются их потоками записи,
+	// поэтому медленный сосед не задерживает остальных
+	for sess := range s.sessions {
+		msgs, err := encodeUpdates(del, add, sess.attrs)
+		if err != nil {
+			// сосед не получит изменений, поэтому сессия разрывается
+			s.logf("BGP %s: %s", sess.conn.RemoteAddr(), err)
+			sess.conn.Close()
+			continue
+		}
+		sess.enqueue(msgs)
+	}
+	return len(add), len(del)
+}
+
+// Текущий анонсируемый набор префиксов по возрастанию
+func (s *Speaker) Routes() []routes.Prefix {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sortedRoutes()
+}
+
+func (s *Speaker) sortedRoutes() []routes.Prefix {
+	prefixes := make([]routes.Prefix, 0, len(s.routes))
+	for p := range s.routes {
+		prefixes = append(prefixes, p)
+	}
+	sortPrefixes(prefixes)
+	return prefixes
+}
+
+func sortPrefixes(prefixes []routes.Prefix) {
+	sort.Slice(prefixes, func(i, j int) bool {
+		if prefixes[i].Addr != prefixes[j].Addr {
+			return prefixes[i].Addr < prefixes[j].Addr
+		}
+		return prefixes[i].Len < prefixes[j].Len
+	})
+}
+
+// Подключение к соседу addr (host:port). При разрыве сессии подключение повторяется через ConnectRetry.
+// Не возвращает управление.
+func (s *Speaker) Connect(addr string) {
+	for {
+		conn, err := net.DialTimeout("tcp", addr, s.cfg.ConnectRetry)
+		if err != nil {
+			s.logf("BGP %s: %s", addr, err)
+		} else {
+			s.logf("BGP %s: session closed: %s", addr, s.Handle(conn))
+		}
+		time.Sleep(s.cfg.ConnectRetry)
+	}
+}
+
+// Прием входящих сессий
+func (s *Speaker) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			s.logf("BGP %s: session closed: %s", conn.RemoteAddr(), s.Handle(conn))
+		}()
+	}
+}
+
+// Ожидание OPEN и KEEPALIVE соседа при установлении сессии (RFC 4271, 8.2.2)
+const openTimeout = 4 * time.Minute
+
+// Обслуживание сессии в установленном соединении до её завершения. Соединение закрывается.
+func (s *Speaker) Handle(conn net.Conn) error {
+	sess := &session{speaker: s, conn: conn, wake: make(chan struct{}, 1)}
+	defer conn.Close()
+
+	err := sess.run()
+	var ne *notificationError
+	if errors.As(err, &ne) {
+		sess.send(msgNotification, encodeNotification(ne.code, ne.subcode))
+	}
+	return err
+}
+
+// Сессия с соседом
+type session struct {
+	speaker *Speaker
+	conn    net.Conn
+	attrs   []byte // атрибуты пути, согласованные с соседом
+
+	writeMu sync.Mutex
+
+	queueMu sync.Mutex
+	queue   [][]byte      // UPDATE, ожидающие отправки
+	wake    chan struct{} // сигнал потоку записи о новых сообщениях в очереди
+}
+
+func (sess *session) send(typ byte, body []byte) error {
+	sess.writeMu.Lock()
+	defer sess.writeMu.Unlock()
+	sess.conn.SetWriteDeadline(time.Now().Add(sess.speaker.cfg.HoldTime))
+	return writeMessage(sess.conn, typ, body)
+}
+
+// Постановка UPDATE в очередь отправки. Порядок сообщений сохраняется.
+func (sess *session) enqueue(msgs [][]byte) {
+	if len(msgs) == 0 {
+		return
+	}
+	sess.queueMu.Lock()
+	sess.queue = append(sess.queue, msgs...)
+	sess.queueMu.Unlock()
+	select {
+	case sess.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Поток записи: отправка UPDATE из очереди до остановки сессии.
+// При ошибке соединение закрывается, и сессия завершится в цикле чтения.
+func (sess *session) writeLoop(stop <-chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		case <-sess.wake:
+		}
+		sess.queueMu.Lock()
+		msgs := sess.queue
+		sess.queue = nil
+		sess.queueMu.Unlock()
+		for _, msg := range msgs {
+			if sess.send(msgUpdate, msg) != nil {
+				sess.conn.Close()
+				return
+			}
+		}
+	}
+}
+
+// Чтение сообщения с ограничением времени ожидания. NOTIFICATION соседа возвращается как ошибка.
+func (sess *session) read(timeout time.Duration) (typ byte, body []byte, err error) {
+	if timeout > 0 {
+		sess.conn.SetReadDeadline(time.Now().Add(timeout))
+	} else {
+		sess.conn.SetReadDeadline(time.Time{})
+	}
+	typ, body, err = readMessage(sess.conn)
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		return 0, nil, &notificationError{errHoldTimer, 0, "hold timer expired"}
+	}
+	if err == nil && typ == msgNotification {
+		pe := &peerNotificationError{}
+		if len(body) >= 2 {
+			pe.code, pe.subcode = body[0], body[1]
+		}
+		return 0, nil, pe
+	}
+	return
+}
+
+func (sess *session) run() error {
+	s := sess.speaker
+	cfg := &s.cfg
+
+	// OpenSent
+	open := &openMessage{AS: cfg.ASN, HoldTime: uint16(cfg.HoldTime / time.Second), RouterID: s.routerID}
+	if err := sess.send(msgOpen, open.encode()); err != nil {
+		return err
+	}
+	typ, body, err := sess.read(openTimeout)
+	if err != nil {
+		return err
+	}
+	if typ != msgOpen {
+		return &notificationError{errFSM, 0, fmt.Sprintf("unexpected message type %d in OpenSent", typ)}
+	}
+	peer, err := decodeOpen(body)
+	if err != nil {
+		return err
+	}
+	if cfg.PeerASN != 0 && peer.AS != cfg.PeerASN {
+		return &notificationError{errOpen, errOpenBadPeer, fmt.Sprintf("unexpected peer AS %d", peer.AS)}
+	}
+	if peer.HoldTime == 1 || peer.HoldTime == 2 {
+		return &notificationError{errOpen, errOpenHoldTime, fmt.Sprintf("unacceptable hold time %d", peer.HoldTime)}
+	}
+	hold := cfg.HoldTime
+	if peerHold := time.Duration(peer.HoldTime) * time.Second; peerHold < hold {
+		hold = peerHold
+	}
+
+	// OpenConfirm: при нулевом времени удержания ожидание KEEPALIVE всё равно ограничено
+	if err := sess.send(msgKeepalive, nil); err != nil {
+		return err
+	}
+	confirmTimeout := hold
+	if confirmTimeout == 0 {
+		confirmTimeout = openTimeout
+	}
+	if typ, _, err = sess.read(confirmTimeout); err != nil {
+		return err
+	}
+	if typ != msgKeepalive {
+		return &notificationError{errFSM, 0, fmt.Sprintf("unexpected message type %d in OpenConfirm", typ)}
+	}
+
+	pa := &pathAttributes{
+		AS:          cfg.ASN,
+		IBGP:        peer.AS == cfg.ASN,
+		FourOctetAS: peer.FourOctetAS,
+		NextHop:     s.nextHop,
+		Communities: cfg.Communities,
+	}
+	sess.attrs = pa.encode()
+
+	// Established: регистрация сессии и постановка полного анонса в очередь под блокировкой,
+	// чтобы изменения набора попали в очередь после него
+	s.mu.Lock()
+	msgs, err := encodeUpdates(nil, s.sortedRoutes(), sess.attrs)
+	if err != nil {
+		s.mu.Unlock()
+		return &notificationError{errCease, 0, err.Error()}
+	}
+	sess.enqueue(msgs)
+	s.sessions[sess] = struct{}{}
+	count := len(s.routes)
+	s.mu.Unlock()
+	s.logf("BGP %s: established with AS %d, announced %d prefixes", sess.conn.RemoteAddr(), peer.AS, count)
+	defer func() {
+		s.mu.Lock()
+		delete(s.sessions, sess)
+		s.mu.Unlock()
+	}()
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go sess.writeLoop(stop)
+	if hold > 0 {
+		go func() {
+			ticker := time.NewTicker(hold / 3)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-stop:
+					return
+				case <-ticker.C:
+					if sess.send(msgKeepalive, nil) != nil {
+						sess.conn.Close()
+						return
+					}
+				}
+			}
+		}()
+	}
+
+	for {
+		typ, _, err := sess.read(hold)
+		if err != nil {
+			return err
+		}
+		switch typ {
+		case msgKeepalive, msgUpdate:
+			// маршруты соседа не используются
+		default:
+			return &notificationError{errFSM, 0, fmt.Sprintf("unexpected message type %d in Established", typ)}
+		}
+	}
+}
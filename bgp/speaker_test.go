@@ -0,0 +1,254 @@
+package bgp
+
+import (
+	"encoding/binary"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/amkulikov/blocked_routes/routes"
+)
+
+func testPrefix(t *testing.T, cidr string) routes.Prefix {
+	_, n, err := net.ParseCIDR(cidr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return routes.PrefixOf(n)
+}
+
+func testSpeaker(t *testing.T, prefixes ...string) *Speaker {
+	sp, err := NewSpeaker(Config{
+		ASN:      4200000001,
+		RouterID: net.ParseIP("10.0.0.1"),
+		NextHop:  net.ParseIP("10.0.0.1"),
+		HoldTime: 9 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ps []routes.Prefix
+	for _, p := range prefixes {
+		ps = append(ps, testPrefix(t, p))
+	}
+	sp.SetRoutes(ps)
+	return sp
+}
+
+// Сообщение от спикера с проверкой типа
+func expectMessage(t *testing.T, conn net.Conn, want byte) []byte {
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	typ, body, err := readMessage(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if typ != want {
+		t.Fatalf("got message type %d, want %d", typ, want)
+	}
+	return body
+}
+
+// Установление сессии со стороны соседа с номером AS 65001 и временем удержания hold.
+// Возвращает OPEN спикера.
+func establish(t *testing.T, sp *Speaker, hold uint16) (net.Conn, []byte) {
+	conn, server := net.Pipe()
+	go sp.Handle(server)
+	return conn, handshake(t, conn, hold)
+}
+
+// Обмен OPEN и KEEPALIVE в соединении со спикером. Возвращает OPEN спикера.
+func handshake(t *testing.T, conn net.Conn, hold uint16) []byte {
+	open := expectMessage(t, conn, msgOpen)
+	peer := openMessage{AS: 65001, HoldTime: hold, RouterID: 0x0a000002, FourOctetAS: true}
+	if err := writeMessage(conn, msgOpen, peer.encode()); err != nil {
+		t.Fatal(err)
+	}
+	expectMessage(t, conn, msgKeepalive)
+	if err := writeMessage(conn, msgKeepalive, nil); err != nil {
+		t.Fatal(err)
+	}
+	return open
+}
+
+// Разбор префиксов NLRI
+func decodePrefixes(t *testing.T, b []byte) (prefixes []string) {
+	for len(b) > 0 {
+		n := 1 + (int(b[0])+7)/8
+		if len(b) < n {
+			t.Fatalf("bad prefix %x", b)
+		}
+		var addr [4]byte
+		copy(addr[:], b[1:n])
+		prefixes = append(prefixes, routes.NewPrefix(binary.BigEndian.Uint32(addr[:]), b[0]).String())
+		b = b[n:]
+	}
+	return prefixes
+}
+
+// Отзываемые и анонсируемые префиксы UPDATE
+func decodeUpdate(t *testing.T, body []byte) (withdrawn, announced []string) {
+	wlen := int(binary.BigEndian.Uint16(body))
+	withdrawn = decodePrefixes(t, body[2:2+wlen])
+	rest := body[2+wlen:]
+	alen := int(binary.BigEndian.Uint16(rest))
+	if alen > 0 {
+		announced = decodePrefixes(t, rest[2+alen:])
+	}
+	return withdrawn, announced
+}
+
+func TestSpeakerSession(t *testing.T) {
+	sp := testSpeaker(t, "1.2.3.0/24", "5.6.7.0/24")
+	conn, body := establish(t, sp, 0)
+	defer conn.Close()
+
+	// в заголовке OPEN 4-байтный номер заменяется на AS_TRANS, сам номер передается в capability
+	if as := binary.BigEndian.Uint16(body[1:]); as != asTrans {
+		t.Errorf("OPEN AS field: got %d, want %d", as, asTrans)
+	}
+	open, err := decodeOpen(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !open.FourOctetAS || open.AS != 4200000001 {
+		t.Errorf("OPEN: got AS %d (4-octet %v), want 4200000001", open.AS, open.FourOctetAS)
+	}
+	if open.HoldTime != 9 {
+		t.Errorf("OPEN hold time: got %d, want 9", open.HoldTime)
+	}
+
+	withdrawn, announced := decodeUpdate(t, expectMessage(t, conn, msgUpdate))
+	if len(withdrawn) != 0 || !reflect.DeepEqual(announced, []string{"1.2.3.0/24", "5.6.7.0/24"}) {
+		t.Errorf("initial UPDATE: got withdrawn %v, announced %v", withdrawn, announced)
+	}
+
+	if a, w := sp.SetRoutes([]routes.Prefix{testPrefix(t, "1.2.3.0/24"), testPrefix(t, "9.9.9.0/24")}); a != 1 || w != 1 {
+		t.Errorf("SetRoutes: got %d announced, %d withdrawn, want 1 and 1", a, w)
+	}
+	withdrawn, _ = decodeUpdate(t, expectMessage(t, conn, msgUpdate))
+	if !reflect.DeepEqual(withdrawn, []string{"5.6.7.0/24"}) {
+		t.Errorf("withdrawn: got %v, want [5.6.7.0/24]", withdrawn)
+	}
+	_, announced = decodeUpdate(t, expectMessage(t, conn, msgUpdate))
+	if !reflect.DeepEqual(announced, []string{"9.9.9.0/24"}) {
+		t.Errorf("announced: got %v, want [9.9.9.0/24]", announced)
+	}
+}
+
+func TestSpeakerSlowPeer(t *testing.T) {
+	sp := testSpeaker(t, "1.2.3.0/24")
+
+	// сосед, не читающий UPDATE, не задерживает SetRoutes и другие сессии
+	slow, _ := establish(t, sp, 90)
+	defer slow.Close()
+	fast, _ := establish(t, sp, 90)
+	defer fast.Close()
+	expectMessage(t, fast, msgUpdate)
+
+	done := make(chan struct{})
+	go func() {
+		sp.SetRoutes(nil)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("SetRoutes blocked by slow peer")
+	}
+	withdrawn, _ := decodeUpdate(t, expectMessage(t, fast, msgUpdate))
+	if !reflect.DeepEqual(withdrawn, []string{"1.2.3.0/24"}) {
+		t.Errorf("withdrawn: got %v, want [1.2.3.0/24]", withdrawn)
+	}
+}
+
+// Сессия с соседом через настоящее TCP-соединение
+func TestSpeakerTCP(t *testing.T) {
+	sp := testSpeaker(t, "1.2.3.0/24")
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go sp.Serve(l)
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	handshake(t, conn, 90)
+
+	_, announced := decodeUpdate(t, expectMessage(t, conn, msgUpdate))
+	if !reflect.DeepEqual(announced, []string{"1.2.3.0/24"}) {
+		t.Errorf("initial UPDATE: got announced %v, want [1.2.3.0/24]", announced)
+	}
+	sp.SetRoutes([]routes.Prefix{testPrefix(t, "5.6.7.0/24")})
+	withdrawn, _ := decodeUpdate(t, expectMessage(t, conn, msgUpdate))
+	if !reflect.DeepEqual(withdrawn, []string{"1.2.3.0/24"}) {
+		t.Errorf("withdrawn: got %v, want [1.2.3.0/24]", withdrawn)
+	}
+	_, announced = decodeUpdate(t, expectMessage(t, conn, msgUpdate))
+	if !reflect.DeepEqual(announced, []string{"5.6.7.0/24"}) {
+		t.Errorf("announced: got %v, want [5.6.7.0/24]", announced)
+	}
+
+	// сосед завершает сессию, спикер закрывает соединение
+	if err := writeMessage(conn, msgNotification, encodeNotification(errCease, 0)); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, _, err := readMessage(conn); err == nil {
+		t.Error("connection is not closed after NOTIFICATION")
+	}
+}
+
+func TestSpeakerOversizedAttributes(t *testing.T) {
+	communities := make([]uint32, updateSpace/4)
+	_, err := NewSpeaker(Config{
+		ASN:         65000,
+		RouterID:    net.ParseIP("10.0.0.1"),
+		NextHop:     net.ParseIP("10.0.0.1"),
+		Communities: communities,
+	})
+	if err == nil {
+		t.Error("NewSpeaker accepted communities that don't fit into UPDATE")
+	}
+
+	prefixes := []routes.Prefix{testPrefix(t, "1.2.3.0/24"), testPrefix(t, "5.6.7.8/32")}
+	for _, tc := range []struct {
+		attrs int
+		msgs  int
+		fail  bool
+	}{
+		{attrs: updateSpace - 10, msgs: 1},
+		{attrs: updateSpace - 5, msgs: 2},
+		{attrs: updateSpace - 4, fail: true},
+		{attrs: 2 * updateSpace, fail: true},
+	} {
+		done := make(chan struct{})
+		var msgs [][]byte
+		go func() {
+			msgs, err = encodeUpdates(prefixes, prefixes, make([]byte, tc.attrs))
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("attributes of %d bytes: encodeUpdates doesn't return", tc.attrs)
+		}
+		if (err != nil) != tc.fail {
+			t.Errorf("attributes of %d bytes: got error %v, want error %v", tc.attrs, err, tc.fail)
+			continue
+		}
+		// отзыв помещается в одно сообщение, анонс - в tc.msgs
+		if !tc.fail && len(msgs) != 1+tc.msgs {
+			t.Errorf("attributes of %d bytes: got %d messages, want %d", tc.attrs, len(msgs), 1+tc.msgs)
+		}
+		for _, msg := range msgs {
+			if headerLen+len(msg) > maxMessageLen {
+				t.Errorf("attributes of %d bytes: message of %d bytes", tc.attrs, headerLen+len(msg))
+			}
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/amkulikov/blocked_routes/bgp"
+	"github.com/amkulikov/blocked_routes/routes"
+)
+
+// Подкоманда bgp: анонс оптимизированных маршрутов соседям по BGP с периодическим обновлением блоклиста
+func runBGP(args []string) {
+	fs := newCommandFlagSet("bgp", append(sourceFlags, "max", "hysteresis")...)
+	asn := fs.Uint("asn", 0, "Local AS number.")
+	peerASN := fs.Uint("peer-asn", 0, "Expected AS number of peers, 0 accepts any.")
+	routerID := fs.String("router-id", "", "BGP router ID (IPv4 address).")
+	nextHop := fs.String("next-hop", "", "Next hop of announced routes.")
+	communities := fs.String("communities", "", "Comma-separated BGP communities asn:value added to announced routes.")
+	peers := fs.String("peers", "", "Comma-separated peers host:port to connect to.")
+	listen := fs.String("listen", "", "Address to accept BGP sessions on, e.g. :179.")
+	hold := fs.Duration("hold", 90*time.Second, "Proposed hold time.")
+	reload := fs.Duration("reload", time.Hour, "Blocklist reload interval, 0 disables periodic reload. SIGHUP reloads immediately.")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s bgp -src <blocklist> -asn <AS> -router-id <IP> -next-hop <IP> (-peers <host:port,...> | -listen <addr>) [flags]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if *flagSrc == "" || *peers == "" && *listen == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+	CheckHysteresis()
+
+	cfg := bgp.Config{
+		ASN:      uint32(*asn),
+		PeerASN:  uint32(*peerASN),
+		RouterID: net.ParseIP(*routerID),
+		NextHop:  net.ParseIP(*nextHop),
+		HoldTime: *hold,
+		Logf:     Log,
+	}
+	if *communities != "" {
+		for _, c := range strings.Split(*communities, ",") {
+			community, err := bgp.ParseCommunity(c)
+			if err != nil {
+				Log("%s", err)
+				os.Exit(2)
+			}
+			cfg.Communities = append(cfg.Communities, community)
+		}
+	}
+	speaker, err := bgp.NewSpeaker(cfg)
+	if err != nil {
+		Log("Unable to start BGP speaker: %s", err)
+		os.Exit(2)
+	}
+
+	// Первая загрузка должна быть успешной, последующие ошибки оставляют прежний набор маршрутов
+	var previous *routes.PreviousRoutes
+	if previous, err = reloadBGPRoutes(speaker, previous); err != nil {
+		Log("%s", err)
+		os.Exit(1)
+	}
+
+	if *listen != "" {
+		l, err := net.Listen("tcp", *listen)
+		if err != nil {
+			Log("Unable to listen: %s", err)
+			os.Exit(1)
+		}
+		go func() {
+			Log("BGP listener stopped: %s", speaker.Serve(l))
+		}()
+	}
+	if *peers != "" {
+		for _, p := range strings.Split(*peers, ",") {
+			go speaker.Connect(strings.TrimSpace(p))
+		}
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	var tick <-chan time.Time
+	if *reload > 0 {
+		tick = time.NewTicker(*reload).C
+	}
+	for {
+		select {
+		case <-hup:
+		case <-tick:
+		}
+		if p, err := reloadBGPRoutes(speaker, previous); err != nil {
+			Log("%s", err)
+		} else {
+			previous = p
+		}
+	}
+}
+
+// Загрузка блоклиста и замена анонсируемых маршрутов. Прежние маршруты сохраняются в пределах -hysteresis,
+// чтобы не создавать лишних изменений у соседей. Возвращает новый набор для следующей загрузки.
+func reloadBGPRoutes(speaker *bgp.Speaker, previous *routes.PreviousRoutes) (*routes.PreviousRoutes, error) {
+	bl, err := LoadBlocklist(false, false)
+	if err != nil {
+		return nil, err
+	}
+	excludedNets, err := LoadAllExcludedNets()
+	if err != nil {
+		return nil, err
+	}
+
+	rs := OptimizeRoutes(bl.SubnetsTree(), excludedNets, previous)
+	prefixes := make([]routes.Prefix, 0, len(rs))
+	nets := make([]*net.IPNet, 0, len(rs))
+	for _, r := range rs {
+		prefixes = append(prefixes, r.Node.Prefix())
+		nets = append(nets, r.Network())
+	}
+	announced, withdrawn := speaker.SetRoutes(prefixes)
+	Log("Routes: %d, announced: %d, withdrawn: %d", len(prefixes), announced, withdrawn)
+	return routes.NewPreviousRoutes(nets, *flagHysteresis), nil
+}
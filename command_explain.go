@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/amkulikov/blocked_routes/routes"
+)
+
+// Подкоманда explain: что стоит за итоговым маршрутом и почему он не разбит дальше
+func runExplain(args []string) {
+	fs := newCommandFlagSet("explain", append(sourceFlags, optimizeFlags...)...)
+	format := fs.String("format", "text", "Explanation format: text, json.")
+	limit := fs.Int("limit", 20, "Max entries, domains and organisations in the explanation.")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s explain [flags] <prefix>...\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if *limit < 0 {
+		Log("Invalid -limit %d", *limit)
+		os.Exit(2)
+	}
+	CheckHysteresis()
+
+	bl, err := LoadBlocklist(true, false)
+	if err != nil {
+		Log("%s", err)
+		os.Exit(1)
+	}
+	records, err := bl.Records()
+	if err != nil {
+		Log("Unable to load blocklist records: %s", err)
+		os.Exit(1)
+	}
+	if records == nil {
+		Log("Blocklist has no source records, save snapshots with -snapshot-records to keep them")
+	}
+	excludedNets, err := LoadAllExcludedNets()
+	if err != nil {
+		Log("%s", err)
+		os.Exit(1)
+	}
+	previous, err := LoadPreviousRoutes()
+	if err != nil {
+		Log("%s", err)
+		os.Exit(1)
+	}
+	rs := OptimizeRoutes(bl.SubnetsTree(), excludedNets, previous)
+
+	explanations := make([]*routes.Explanation, 0, fs.NArg())
+	for _, arg := range fs.Args() {
+		_, prefix, err := net.ParseCIDR(arg)
+		if err != nil || prefix.IP.To4() == nil {
+			Log("Invalid IPv4 prefix %q", arg)
+			os.Exit(1)
+		}
+		route := findEmittedRoute(routes.PrefixOf(prefix), rs)
+		if route == nil {
+			Log("%s is not covered by any emitted route", prefix)
+			os.Exit(1)
+		}
+		if route.Network().String() != prefix.String() {
+			Log("%s is not an emitted route, explaining covering route %s", prefix, route.Network())
+		}
+		explanations = append(explanations, routes.Explain(route, rs, records, *limit))
+	}
+
+	switch *format {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(explanations)
+	case "text":
+		for _, e := range explanations {
+			if err = e.WriteText(os.Stdout); err != nil {
+				break
+			}
+		}
+	default:
+		err = fmt.Errorf("unknown format %q", *format)
+	}
+	if err != nil {
+		Log("Unable to write explanation: %s", err)
+		os.Exit(1)
+	}
+}
+
+// Поиск итогового маршрута, совпадающего с префиксом или содержащего его
+func findEmittedRoute(prefix routes.Prefix, rs []*routes.Route) *routes.Route {
+	for _, r := range rs {
+		if r.Node.Prefix().Contains(prefix) {
+			return r
+		}
+	}
+	return nil
+}
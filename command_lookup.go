@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/amkulikov/blocked_routes/routes"
+	"github.com/amkulikov/ipv4range"
+)
+
+// Подкоманда lookup: заблокирован ли адрес или хост, каким маршрутом он покрыт и из-за каких записей блоклиста
+func runLookup(args []string) {
+	fs := newCommandFlagSet("lookup", append(sourceFlags, optimizeFlags...)...)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s lookup [flags] <ip or host>...\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	bl, err := LoadBlocklist(true, false)
+	if err != nil {
+		Log("%s", err)
+		os.Exit(1)
+	}
+	records, err := bl.Records()
+	if err != nil {
+		Log("Unable to load blocklist records: %s", err)
+		os.Exit(1)
+	}
+	if records == nil {
+		Log("Blocklist has no source records, save snapshots with -snapshot-records to keep them")
+	}
+	excludedNets, err := LoadAllExcludedNets()
+	if err != nil {
+		Log("%s", err)
+		os.Exit(1)
+	}
+	previous, err := LoadPreviousRoutes()
+	if err != nil {
+		Log("%s", err)
+		os.Exit(1)
+	}
+
+	// Оптимизация изменяет дерево, поэтому для проверки блокировки сохраняем исходное
+	root := bl.SubnetsTree()
+	blocked := root.Clone()
+	rs := OptimizeRoutes(root, excludedNets, previous)
+
+	for _, arg := range fs.Args() {
+		ips, err := lookupHost(arg)
+		if err != nil {
+			fmt.Printf("%s: %s\n", arg, err)
+			continue
+		}
+		for _, ip := range ips {
+			if ip.String() == arg {
+				fmt.Printf("%s\n", ip)
+			} else {
+				fmt.Printf("%s (%s)\n", ip, arg)
+			}
+			printLookup(ip, blocked, records, rs, excludedNets)
+		}
+	}
+}
+
+// Адреса IPv4 хоста или адреса, переданного как есть
+func lookupHost(host string) ([]net.IP, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		if ip.To4() == nil {
+			return nil, fmt.Errorf("only IPv4 addresses are supported")
+		}
+		return []net.IP{ip.To4()}, nil
+	}
+
+	addrs, err := net.LookupIP(host)
+	if err != nil {
+		return nil, err
+	}
+	ips := make([]net.IP, 0, len(addrs))
+	for _, a := range addrs {
+		if a.To4() != nil {
+			ips = append(ips, a.To4())
+		}
+	}
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("no IPv4 addresses")
+	}
+	return ips, nil
+}
+
+// Вывод сведений об адресе
+func printLookup(ip net.IP, blocked *routes.IPTreeNode, records *routes.RecordIndex, rs []*routes.Route, excludedNets []*net.IPNet) {
+	ipNum := binary.BigEndian.Uint32(ip)
+
+	if match := blocked.LongestMatch(ipv4range.IPv4(ipNum)); match.IsLeaf {
+		fmt.Printf("  blocked: yes, entry %s\n", match.Network())
+	} else {
+		fmt.Printf("  blocked: no\n")
+	}
+
+	route, exception := findRoute(ip, rs)
+	switch {
+	case route == nil:
+		fmt.Printf("  route: none\n")
+	case exception != nil:
+		fmt.Printf("  route: %s, but excepted by %s (net_gateway)\n", route.Network(), exception)
+	default:
+		fmt.Printf("  route: %s\n", route.Network())
+	}
+
+	hits := make([]string, 0)
+	for _, n := range excludedNets {
+		if n.Contains(ip) {
+			hits = append(hits, n.String())
+		}
+	}
+	if len(hits) > 0 {
+		fmt.Printf("  excluded by: %s\n", strings.Join(hits, ", "))
+	}
+
+	if records == nil {
+		return
+	}
+	for _, rec := range records.Lookup(ipNum) {
+		fmt.Printf("  line %d: %s; %s; %s; %s; %s\n", rec.Line, rec.IPs, rec.Domain, rec.Org, rec.Decision, rec.Date)
+	}
+}
+
+// Поиск маршрута, содержащего ip, и исключения маршрута, в которое ip попадает
+func findRoute(ip net.IP, rs []*routes.Route) (route *routes.Route, exception *net.IPNet) {
+	for _, r := range rs {
+		if !r.Network().Contains(ip) {
+			continue
+		}
+		for _, e := range r.Exceptions {
+			if e.Contains(ip) {
+				return r, e
+			}
+		}
+		return r, nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/amkulikov/blocked_routes/routes"
+)
+
+// Подкоманда pareto: вывод кривой зависимости лишних адресов от кол-ва маршрутов
+func runPareto(args []string) {
+	fs := newCommandFlagSet("pareto", sourceFlags...)
+	format := fs.String("format", "csv", "Curve format: csv, json.")
+	fs.Parse(args)
+
+	bl, err := LoadBlocklist(false, false)
+	if err != nil {
+		Log("%s", err)
+		os.Exit(1)
+	}
+	root := bl.SubnetsTree()
+	excludedNets, err := LoadAllExcludedNets()
+	if err != nil {
+		Log("%s", err)
+		os.Exit(1)
+	}
+
+	curve := routes.ParetoCurve(root, excludedNets)
+	switch *format {
+	case "csv":
+		err = routes.WriteParetoCSV(os.Stdout, curve)
+	case "json":
+		err = routes.WriteParetoJSON(os.Stdout, curve)
+	default:
+		err = fmt.Errorf("unknown format %q", *format)
+	}
+	if err != nil {
+		Log("Unable to write curve: %s", err)
+		os.Exit(1)
+	}
+
+	knee := routes.ParetoKnee(curve)
+	Log("Points: %d, knee: %d routes, collateral: %d", len(curve), knee.Routes, knee.Collateral)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/amkulikov/blocked_routes/routes"
+)
+
+// Подкоманда setop: объединение, пересечение или разность списков подсетей.
+// Операция применяется к файлам слева направо, результат выводится в формате -output.
+func runSetop(args []string) {
+	fs := newCommandFlagSet("setop", append([]string{"silent"}, outputFlags...)...)
+	op := fs.String("op", "union", "Set operation: union, intersect, difference.")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s setop [flags] <file> <file>...\n", os.Args[0])
+		fmt.Fprintf(fs.Output(), "Files contain nets in any output format, \"-\" reads stdin.\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() < 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	var apply func(a, b *routes.IPTreeNode) *routes.IPTreeNode
+	switch *op {
+	case "union":
+		apply = (*routes.IPTreeNode).Union
+	case "intersect":
+		apply = (*routes.IPTreeNode).Intersect
+	case "difference":
+		apply = (*routes.IPTreeNode).Difference
+	default:
+		Log("Unknown set operation %q", *op)
+		os.Exit(2)
+	}
+
+	var result *routes.IPTreeNode
+	for _, path := range fs.Args() {
+		tree, err := loadNetsTree(path)
+		if err != nil {
+			Log("Unable to load nets from %s: %s", path, err)
+			os.Exit(1)
+		}
+		if result == nil {
+			result = tree
+		} else {
+			result = apply(result, tree)
+		}
+	}
+
+	leaves := result.Leaves()
+	rs := make([]*routes.Route, 0, len(leaves))
+	for _, l := range leaves {
+		rs = append(rs, &routes.Route{Node: l})
+	}
+	outputOpts, err := OutputOptions()
+	if err != nil {
+		Log("%s", err)
+		os.Exit(1)
+	}
+	outputOpts.Meta = routes.OutputMeta{
+		Source:    strings.Join(fs.Args(), ","),
+		Generated: time.Now(),
+		Version:   version,
+		Options:   map[string]string{"op": *op},
+	}
+	changed, err := WriteOutput(rs, outputOpts)
+	if err != nil {
+		Log("Unable to output nets: %s", err)
+		os.Exit(1)
+	}
+	Log("Total nets: %d", len(rs))
+	if !changed {
+		Log("Output file %s is unchanged", *flagOut)
+		os.Exit(exitUnchanged)
+	}
+}
+
+// Загрузка списка подсетей из файла в дерево
+func loadNetsTree(path string) (*routes.IPTreeNode, error) {
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	nets, err := routes.ParseRoutes(r)
+	if err != nil {
+		return nil, err
+	}
+	return routes.NewIPTree(nets), nil
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"flag"
+)
+
+// Подкоманды. Подкоманда указывается первым аргументом, после неё следуют её ключи.
+var commands = map[string]func(args []string){
+	"pareto":  runPareto,
+	"lookup":  runLookup,
+	"explain": runExplain,
+	"setop":   runSetop,
+	"bgp":     runBGP,
+}
+
+// Создание набора ключей подкоманды. Перечисленные ключи основной команды переносятся в набор как есть.
+func newCommandFlagSet(name string, inherit ...string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	for _, n := range inherit {
+		f := flag.Lookup(n)
+		fs.Var(f.Value, f.Name, f.Usage)
+	}
+	return fs
+}
+
+// Ключи загрузки блоклиста, общие для подкоманд
+var sourceFlags = []string{"src", "silent", "empty-domains", "allowed-domains", "exclude", "save-snapshot"}
+
+// Ключи формата вывода
+var outputFlags = []string{"output", "out", "wg-public-key", "wg-endpoint", "wg-extra", "wg-max-line",
+	"bird-protocol", "bird-next-hop", "bird-recursive", "bird-communities",
+	"routeros-list", "routeros-gateway", "routeros-table", "routeros-comment",
+	"set-name", "nft-table", "nft-family",
+	"iproute2-dev", "iproute2-via", "iproute2-table", "iproute2-metric", "iproute2-proto",
+	"pac-proxy", "dns-server", "unbound-zone-type", "xray-outbound", "template"}
+
+// Ключи оптимизации, общие для подкоманд, которым нужен итоговый набор маршрутов
+var optimizeFlags = []string{"max", "exceptions", "previous", "hysteresis"}
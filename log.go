@@ -0,0 +1,19 @@
+package main
+
+import (
+	"log"
+)
+
+func Dump(data... interface{}) {
+	if *flagSilent {
+		return
+	}
+	log.Println(data...)
+}
+
+func Log(f string, data... interface{}) {
+	if *flagSilent {
+		return
+	}
+	log.Printf(f, data...)
+}
@@ -4,70 +4,372 @@ import (
 	"net"
 	"flag"
 	"os"
-	"net/url"
+	"strconv"
+	"strings"
+	"fmt"
+	"time"
+	"bytes"
+
+	"github.com/amkulikov/blocked_routes/routes"
 )
 
+// Код завершения, если содержимое файла -out не изменилось. Код 2 занят ошибками ключей запуска.
+const exitUnchanged = 3
+
+// Версия утилиты, задается при сборке: go build -ldflags "-X main.version=..."
+var version = "dev"
+
 var (
 	flagSrc              = flag.String("src", "", "Location of blocklist file. It may be URL or filepath.")
-	flagMaxNets          = flag.Uint("max", uint(^uint32(0)), "Max subnets in output.")
+	flagMaxNets          = &maxNetsValue{max: uint(^uint32(0))}
 	flagSilent           = flag.Bool("silent", false, "Prevent errors at stderr.")
 	flagAllowEmptyDomain = flag.Bool("empty-domains", false, "Use rules with empty domains from blocklist.")
 	flagAllowDomains     = flag.String("allowed-domains", "", "Use only allowed domains from blocklist rules. Not contains empty domains.")
 	flagExcludeNets      = flag.String("exclude", "", "Comma-separated nets in CIDR that must be excluded from result. Private subnets always excluded.")
-	flagOutputFormat     = flag.String("output", "default", "Output format: default, cidr, ovpn, push-ovpn.")
+	flagOutputFormat     = flag.String("output", "default", "Output format: default, cidr, ovpn, push-ovpn, wireguard, wg-quick, bird, bird2, routeros-address-list, routeros-route, ipset, nft, iproute2, json, yaml, pac, dnsmasq-ipset, dnsmasq-nftset, dnsmasq-server, unbound, sing-box, xray, clash.")
+	flagWGPublicKey      = flag.String("wg-public-key", "", "Peer public key for wg-quick output.")
+	flagWGEndpoint       = flag.String("wg-endpoint", "", "Peer endpoint host:port for wg-quick output.")
+	flagWGExtra          = flag.String("wg-extra", "", "Comma-separated nets in CIDR (IPv4 or IPv6) appended to AllowedIPs.")
+	flagWGMaxLine        = flag.Int("wg-max-line", 0, "Max length of AllowedIPs line, longer lists are split into several lines. 0 means no limit.")
+	flagBirdProtocol     = flag.String("bird-protocol", "blocked_routes", "Name of BIRD static protocol for bird and bird2 output.")
+	flagBirdNextHop      = flag.String("bird-next-hop", "", "Next hop of routes for bird and bird2 output.")
+	flagBirdRecursive    = flag.Bool("bird-recursive", false, "Use recursive routes for bird and bird2 output.")
+	flagBirdCommunities  = flag.String("bird-communities", "", "Comma-separated BGP communities asn:value added to routes in bird and bird2 output.")
+	flagROSList          = flag.String("routeros-list", "blocked", "Address list name for routeros-address-list output.")
+	flagROSGateway       = flag.String("routeros-gateway", "", "Gateway for routeros-route output.")
+	flagROSTable         = flag.String("routeros-table", "", "Routing table for routeros-route output.")
+	flagROSComment       = flag.String("routeros-comment", "blocked_routes", "Comment marking entries managed by RouterOS scripts.")
+	flagROSDiff          = flag.Bool("routeros-diff", false, "Only add and remove entries changed since -previous routes in RouterOS scripts.")
+	flagSetName          = flag.String("set-name", "blocked", "Set name for ipset, nft, dnsmasq-ipset and dnsmasq-nftset output.")
+	flagNftTable         = flag.String("nft-table", "blocked_routes", "Table of the set for nft and dnsmasq-nftset output.")
+	flagNftFamily        = flag.String("nft-family", "inet", "Family of the table for nft and dnsmasq-nftset output.")
+	flagIPRoute2Dev      = flag.String("iproute2-dev", "", "Device of routes for iproute2 output.")
+	flagIPRoute2Via      = flag.String("iproute2-via", "", "Gateway of routes for iproute2 output.")
+	flagIPRoute2Table    = flag.String("iproute2-table", "", "Routing table for iproute2 output.")
+	flagIPRoute2Metric   = flag.Int("iproute2-metric", 0, "Metric of routes for iproute2 output.")
+	flagIPRoute2Proto    = flag.String("iproute2-proto", "", "Protocol of routes for iproute2 output.")
+	flagIPRoute2Cleanup  = flag.String("iproute2-cleanup", "", "Remove old routes in iproute2 output: flush the table, or delete routes missing since -previous.")
+	flagPACProxy         = flag.String("pac-proxy", "", "Proxy for blocked hosts in pac output, e.g. \"HTTPS proxy.example.com:443\".")
+	flagDNSServer        = flag.String("dns-server", "", "DNS server for blocked domains in dnsmasq and unbound output: address, address#port or address@port.")
+	flagUnboundZoneType  = flag.String("unbound-zone-type", "always_nxdomain", "local-zone type for unbound output without -dns-server.")
+	flagXrayOutbound     = flag.String("xray-outbound", "proxy", "outboundTag of routing rules for xray output.")
+	flagOut              = flag.String("out", "", "Write output atomically to the file instead of stdout. The file is left untouched when content is the same, then exit code is 3.")
+	flagTemplate         = flag.String("template", "", "Output routes with text/template file instead of -output format.")
+	flagReport           = flag.String("report", "", "Write optimisation quality report in JSON to the file.")
+	flagPrevious         = flag.String("previous", "", "File with routes of the previous run in any output format except nft with exceptions. Previous routes are kept while the penalty difference is within -hysteresis.")
+	flagHysteresis       = flag.Float64("hysteresis", 0.1, "Relative penalty difference from 0 to 1 within which previous routes are kept.")
+	flagExceptions       = flag.Bool("exceptions", false, "Allow routes with net_gateway exceptions when it cuts route count or collateral. Only for ovpn, push-ovpn, ipset, nft, iproute2, json, yaml, pac, sing-box, xray and clash output.")
+	flagSaveSnapshot     = flag.String("save-snapshot", "", "Save parsed blocklist into binary snapshot file, usable as -src later.")
+	flagSnapshotRecords  = flag.Bool("snapshot-records", false, "Keep source records in -save-snapshot for lookup and explain.")
+	flagCompact          = flag.Bool("compact", false, "Use memory-compact path-compressed tree, parsed directly from the blocklist into prefixes.")
 )
 
+func init() {
+	flag.Var(flagMaxNets, "max", "Max subnets in output, or \"auto\" to choose the knee of routes/collateral curve.")
+}
+
+// Значение ключа -max: число либо auto
+type maxNetsValue struct {
+	max  uint
+	auto bool
+}
+
+func (v *maxNetsValue) String() string {
+	if v.auto {
+		return "auto"
+	}
+	return strconv.FormatUint(uint64(v.max), 10)
+}
+
+func (v *maxNetsValue) Set(s string) error {
+	if s == "auto" {
+		v.auto = true
+		return nil
+	}
+	n, err := strconv.ParseUint(s, 10, 0)
+	if err != nil {
+		return err
+	}
+	v.max, v.auto = uint(n), false
+	return nil
+}
+
 func main() {
-	var err error
+	// Подкоманда передается первым аргументом
+	if len(os.Args) > 1 {
+		if cmd, ok := commands[os.Args[1]]; ok {
+			cmd(os.Args[2:])
+			return
+		}
+	}
 	flag.Parse()
+	CheckHysteresis()
 
+	outputOpts, err := OutputOptions()
+	if err != nil {
+		Log("%s", err)
+		os.Exit(1)
+	}
+	if *flagExceptions && !routes.FormatSupportsExceptions(outputOpts.Format) {
+		Log("Output format %q doesn't support route exceptions", outputOpts.Format)
+		os.Exit(1)
+	}
+
+	bl, err := LoadBlocklist(false, routes.FormatUsesDomains(outputOpts.Format))
+	if err != nil {
+		Log("%s", err)
+		os.Exit(1)
+	}
+
+	excludedNets, err := LoadAllExcludedNets()
+	if err != nil {
+		Log("%s", err)
+		os.Exit(1)
+	}
+
+	previous, err := LoadPreviousRoutes()
+	if err != nil {
+		Log("%s", err)
+		os.Exit(1)
+	}
+
+	// Формируем дерево подсетей из блоклиста.
+	// Кол-во заблокированных адресов до исключения подсетей нужно для отчета.
+	var rs []*routes.Route
+	var blockedBefore uint64
+	if *flagCompact {
+		compactTree := bl.CompactTree()
+		blockedBefore = uint64(compactTree.Size())
+		rs = OptimizeRoutes(compactTree, excludedNets, previous)
+	} else {
+		netsTreeRoot := bl.SubnetsTree()
+		blockedBefore = uint64(netsTreeRoot.SubtreeSize)
+		rs = OptimizeRoutes(netsTreeRoot, excludedNets, previous)
+	}
+
+	report := routes.NewReport(rs, blockedBefore)
+	if previous != nil {
+		added, removed := previous.Diff(rs)
+		report.Churn = &routes.ReportChurn{Added: len(added), Removed: len(removed)}
+		Log("Routes added: %d, removed: %d", len(added), len(removed))
+	}
+
+	outputOpts.Previous = previous
+	outputOpts.Report = report
+	outputOpts.Meta = routes.OutputMeta{
+		Source:    *flagSrc,
+		Updated:   bl.Updated(),
+		Generated: time.Now(),
+		Version:   version,
+		Options:   usedOptions(),
+	}
+	if outputOpts.Meta.Source == "" {
+		outputOpts.Meta.Source = "stdin"
+	}
+	if routes.FormatUsesDomains(outputOpts.Format) {
+		if outputOpts.Domains = bl.Domains(); outputOpts.Domains == nil {
+			Log("Blocklist has no domains, only routes are used")
+		}
+	}
+	// отчет пишется до -out: при ошибке отчета файл результата остается прежним
+	if *flagReport != "" {
+		if err := report.WriteFile(*flagReport); err != nil {
+			Log("Unable to write report: %s", err)
+			os.Exit(1)
+		}
+	}
+	changed, err := WriteOutput(rs, outputOpts)
+	if err != nil {
+		Log("Unable to output routes: %s", err)
+		os.Exit(1)
+	}
+
+	exceptions := 0
+	for _, r := range rs {
+		exceptions += len(r.Exceptions)
+	}
+	Log("Total nets: %d, exceptions: %d, excluded: %d", len(rs), exceptions, len(excludedNets))
+	if !changed {
+		Log("Output file %s is unchanged", *flagOut)
+		os.Exit(exitUnchanged)
+	}
+}
+
+// Вывод маршрутов в stdout либо атомарно в файл -out.
+// Возвращает false, если файл -out уже содержал тот же результат.
+func WriteOutput(rs []*routes.Route, opts routes.OutputOptions) (bool, error) {
+	var buf bytes.Buffer
+	if err := routes.OutputNets(&buf, rs, opts); err != nil {
+		return false, err
+	}
+	if opts.Format == "pac" {
+		Log("PAC file size: %d bytes, domains: %d", buf.Len(), len(opts.Domains))
+	}
+	if *flagOut == "" {
+		_, err := os.Stdout.Write(buf.Bytes())
+		return true, err
+	}
+	return routes.WriteFileIfChanged(*flagOut, buf.Bytes())
+}
+
+// Значения ключей, влияющих на итоговый набор маршрутов
+func usedOptions() map[string]string {
+	options := make(map[string]string)
+	for _, name := range []string{"max", "exclude", "empty-domains", "allowed-domains", "exceptions", "previous", "hysteresis", "compact"} {
+		options[name] = flag.Lookup(name).Value.String()
+	}
+	return options
+}
+
+// Параметры вывода маршрутов из ключей
+func OutputOptions() (routes.OutputOptions, error) {
+	opts := routes.OutputOptions{
+		Format: *flagOutputFormat,
+		WireGuard: routes.WireGuardOptions{
+			PublicKey:     *flagWGPublicKey,
+			Endpoint:      *flagWGEndpoint,
+			MaxLineLength: *flagWGMaxLine,
+		},
+	}
+	if *flagWGExtra != "" {
+		opts.WireGuard.Extra = strings.Split(*flagWGExtra, ",")
+	}
+	opts.Bird = routes.BirdOptions{
+		Protocol:  *flagBirdProtocol,
+		NextHop:   *flagBirdNextHop,
+		Recursive: *flagBirdRecursive,
+	}
+	if *flagBirdCommunities != "" {
+		opts.Bird.Communities = strings.Split(*flagBirdCommunities, ",")
+	}
+	opts.RouterOS = routes.RouterOSOptions{
+		List:         *flagROSList,
+		Gateway:      *flagROSGateway,
+		RoutingTable: *flagROSTable,
+		Comment:      *flagROSComment,
+		Diff:         *flagROSDiff,
+	}
+	opts.Set = routes.SetOptions{
+		Name:      *flagSetName,
+		NftTable:  *flagNftTable,
+		NftFamily: *flagNftFamily,
+	}
+	opts.IPRoute2 = routes.IPRoute2Options{
+		Dev:      *flagIPRoute2Dev,
+		Gateway:  *flagIPRoute2Via,
+		Table:    *flagIPRoute2Table,
+		Metric:   *flagIPRoute2Metric,
+		Protocol: *flagIPRoute2Proto,
+		Cleanup:  *flagIPRoute2Cleanup,
+	}
+	opts.PAC = routes.PACOptions{Proxy: *flagPACProxy}
+	opts.DNS = routes.DNSOptions{
+		Server:   *flagDNSServer,
+		ZoneType: *flagUnboundZoneType,
+	}
+	opts.Proxy = routes.ProxyOptions{XrayOutbound: *flagXrayOutbound}
+	if *flagTemplate != "" {
+		tmpl, err := routes.ParseTemplateFile(*flagTemplate)
+		if err != nil {
+			return opts, fmt.Errorf("Unable to load template: %s", err)
+		}
+		opts.Format, opts.Template = "template", tmpl
+	}
+	if !routes.KnownFormat(opts.Format) {
+		return opts, fmt.Errorf("Unknown output format %q", opts.Format)
+	}
+	return opts, nil
+}
+
+// Проверка ключа -hysteresis: доля от 0 до 1
+func CheckHysteresis() {
+	if !routes.ValidTolerance(*flagHysteresis) {
+		Log("Invalid -hysteresis %v, must be between 0 and 1", *flagHysteresis)
+		os.Exit(2)
+	}
+}
+
+// Загрузка маршрутов предыдущего запуска, если они указаны
+func LoadPreviousRoutes() (*routes.PreviousRoutes, error) {
+	if *flagPrevious == "" {
+		return nil, nil
+	}
+	previous, err := routes.LoadPreviousRoutes(*flagPrevious, *flagHysteresis)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to load previous routes: %s", err)
+	}
+	return previous, nil
+}
+
+// Формирование маршрутов с параметрами оптимизации из ключей
+func OptimizeRoutes(tree routes.OptimizeTree, excludedNets []*net.IPNet, previous *routes.PreviousRoutes) []*routes.Route {
+	maxNets := flagMaxNets.max
+	if flagMaxNets.auto {
+		// Выбираем бюджет маршрутов по точке перегиба кривой Парето
+		knee := routes.ParetoKnee(routes.ParetoCurve(tree, excludedNets))
+		maxNets = knee.Routes
+		Log("Auto max: %d, collateral: %d", knee.Routes, knee.Collateral)
+	}
+
+	return routes.GetOptimizedRoutes(tree, routes.OptimizeOptions{
+		ExcludeNets: excludedNets,
+		MaxNets:     maxNets,
+		Exceptions:  *flagExceptions,
+		Previous:    previous,
+	})
+}
+
+// Загрузка блоклиста из источника, указанного ключами. При keepRecords сохраняются исходные записи блоклиста.
+func LoadBlocklist(keepRecords, keepDomains bool) (*routes.Blocklist, error) {
 	// Создаем парсер блоклиста (данных о заблокированных ресурсах)
-	blParser := &ZapretInfoParser{
+	blParser := &routes.ZapretInfoParser{
 		AllowEmptyDomain: *flagAllowEmptyDomain || *flagAllowDomains == "",
+		// снимок сохраняется с доменами, чтобы из него работали форматы доменов,
+		// исходные записи для lookup и explain - только с -snapshot-records: они в разы увеличивают снимок
+		KeepRecords: keepRecords || *flagSaveSnapshot != "" && *flagSnapshotRecords,
+		KeepDomains: keepDomains || *flagSaveSnapshot != "",
 	}
 	if *flagAllowDomains != "" {
 		// Разрешаем парсеру включать в список только перечисленные домены (с поддоменами)
 		if err := blParser.LoadAllowedDomains(*flagAllowDomains); err != nil {
-			Log("Unable to load allowed domains: %s", err)
-			os.Exit(1)
+			return nil, fmt.Errorf("Unable to load allowed domains: %s", err)
 		}
 	}
 
 	// Инициализируем блоклист и устанавливаем парсер
-	bl := NewBlocklist()
+	bl := routes.NewBlocklist()
 	bl.SetParser(blParser)
+	bl.SetCompact(*flagCompact)
 
 	// Выбираем источник данных о заблокированных ресурсах
+	var err error
 	if *flagSrc == "" {
 		err = bl.Parse(os.Stdin)
-	} else if u, err := url.Parse(*flagSrc); err == nil && u.IsAbs() {
-		err = bl.LoadFromURL(u)
 	} else {
-		err = bl.LoadFromFile(*flagSrc)
+		err = bl.Load(*flagSrc)
 	}
 	if err != nil {
-		Log("Unable to load blocklist: %s", err)
-		os.Exit(1)
+		return nil, fmt.Errorf("Unable to load blocklist: %s", err)
 	}
+	if *flagSaveSnapshot != "" {
+		if err := bl.SaveSnapshot(*flagSaveSnapshot); err != nil {
+			return nil, fmt.Errorf("Unable to save snapshot: %s", err)
+		}
+	}
+	return bl, nil
+}
 
-	// Формируем дерево подсетей из блоклиста
-	netsTreeRoot := bl.SubnetsTree()
-
+// Загрузка подсетей, исключаемых из результата
+func LoadAllExcludedNets() ([]*net.IPNet, error) {
 	// Частные сети исключаем всегда
-	excludedNets := []*net.IPNet{privateNet8, privateNet12, privateNet16}
+	excludedNets := routes.PrivateNets()
 	if *flagExcludeNets != "" {
 		// Добавляем для исключения указанные дополнительные сети
-		en, err := LoadExcludedNets(*flagExcludeNets)
+		en, err := routes.LoadExcludedNets(*flagExcludeNets)
 		if err != nil {
-			Log("Unable to load excluded nets: %s", err)
-			os.Exit(1)
+			return nil, fmt.Errorf("Unable to load excluded nets: %s", err)
 		}
 		excludedNets = append(excludedNets, en...)
 	}
-
-	ipNets := GetOptimizedNets(netsTreeRoot, excludedNets, *flagMaxNets)
-
-	OutputNets(ipNets)
-
-	Log("Total nets: %d, excluded: %d", len(ipNets), len(excludedNets))
+	return excludedNets, nil
 }
@@ -0,0 +1,66 @@
+package routes
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Атомарная запись data в файл path: данные пишутся во временный файл в том же каталоге,
+// сбрасываются на диск и переименовываются в path. Если файл уже содержит data, он не изменяется
+// и возвращается changed == false. Права существующего файла сохраняются, новый файл создается с 0644.
+func WriteFileIfChanged(path string, data []byte) (changed bool, err error) {
+	mode := os.FileMode(0644)
+	if fi, err := os.Stat(path); err == nil {
+		mode = fi.Mode().Perm()
+		if fi.Size() == int64(len(data)) {
+			if old, err := ioutil.ReadFile(path); err == nil && bytes.Equal(old, data) {
+				return false, nil
+			}
+		}
+	} else if !os.IsNotExist(err) {
+		return false, err
+	}
+
+	dir, base := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+	f, err := ioutil.TempFile(dir, "."+base+".tmp")
+	if err != nil {
+		return false, err
+	}
+	// при ошибке временный файл удаляется, исходный остается нетронутым
+	defer func() {
+		if err != nil {
+			os.Remove(f.Name())
+		}
+	}()
+
+	if _, err = f.Write(data); err != nil {
+		f.Close()
+		return false, err
+	}
+	if err = f.Chmod(mode); err != nil {
+		f.Close()
+		return false, err
+	}
+	if err = f.Sync(); err != nil {
+		f.Close()
+		return false, err
+	}
+	if err = f.Close(); err != nil {
+		return false, err
+	}
+	if err = os.Rename(f.Name(), path); err != nil {
+		return false, err
+	}
+
+	// сброс каталога фиксирует переименование; ошибка не критична, файл уже на месте
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return true, nil
+}
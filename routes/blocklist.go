@@ -0,0 +1,316 @@
+package routes
+
+import (
+	"net"
+	"github.com/amkulikov/ipv4range"
+	"os"
+	"net/url"
+	"net/http"
+	"io"
+	"errors"
+	"bufio"
+	"runtime"
+	"sync"
+)
+
+// Интерфейс парсера списка заблокированных ресурсов
+type BlocklistParser interface {
+	// Читает содержимое блоклиста и возвращает отдельные IP-адреса в ips,
+	// заблокированные сети в nets, err в случае ошибки.
+	// nets могут включать адреса из ips, а также содержать в себе другие элементы nets.
+	Parse(r io.Reader) (ips map[ipv4range.IPv4]struct{}, nets []*net.IPNet, err error)
+}
+
+// Парсер, сохраняющий исходные записи блоклиста
+type BlocklistRecordsParser interface {
+	BlocklistParser
+	// Индекс исходных записей, заполненный последним вызовом Parse, либо nil
+	Records() *RecordIndex
+}
+
+// Парсер, извлекающий время формирования блоклиста
+type BlocklistUpdatedParser interface {
+	BlocklistParser
+	// Время формирования блоклиста из последнего вызова Parse, либо пустая строка
+	Updated() string
+}
+
+// Парсер, сохраняющий домены правил блоклиста
+type BlocklistDomainsParser interface {
+	BlocklistParser
+	// Домены правил из последнего вызова Parse, либо nil
+	Domains() []string
+}
+
+// Парсер, возвращающий префиксы без множества адресов и отдельных net.IPNet (см. Blocklist.SetCompact)
+type BlocklistPrefixesParser interface {
+	BlocklistParser
+	// Читает содержимое блоклиста и возвращает адреса (префиксы /32) и сети в порядке записей, возможно с повторами
+	ParsePrefixes(r io.Reader) ([]Prefix, error)
+}
+
+// Список заблокированных ресурсов
+type Blocklist struct {
+	nets       []*net.IPNet                // заблокированные сети
+	ips        map[ipv4range.IPv4]struct{} // заблокированные отдельные IP
+	prefixList []Prefix                    // префиксы, загруженные из снимка или разобранные в режиме compact
+	compact    bool                        // разбор в prefixList для CompactTree, см. SetCompact
+
+	records     *RecordIndex    // исходные записи, если парсер их сохраняет
+	recordsData *snapshotReader // неразобранные записи снимка, разбираются при первом обращении к Records
+	updated     string          // время формирования блоклиста, если оно известно
+	domains     []string        // нормализованные домены, если парсер их сохраняет
+
+	parser BlocklistParser // парсер исходного списка ресурсов
+}
+
+// Инициализация нового блоклиста
+func NewBlocklist() *Blocklist {
+	return &Blocklist{
+		ips: make(map[ipv4range.IPv4]struct{}),
+	}
+}
+
+// Загрузка блоклиста из файла
+func (b *Blocklist) LoadFromFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return b.Parse(f)
+}
+
+// Загрузка блоклиста по URL
+func (b *Blocklist) LoadFromURL(url *url.URL) error {
+	res, err := http.Get(url.String())
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != http.StatusOK {
+		return errors.New(res.Status)
+	}
+	defer res.Body.Close()
+
+	return b.Parse(res.Body)
+}
+
+// Загрузка блоклиста из src, который может быть URL или путем к файлу
+func (b *Blocklist) Load(src string) error {
+	if u, err := url.Parse(src); err == nil && u.IsAbs() {
+		return b.LoadFromURL(u)
+	}
+	return b.LoadFromFile(src)
+}
+
+// Загрузка блоклиста из io.Reader. Бинарный снимок (см. WriteSnapshot) распознается по заголовку.
+func (b *Blocklist) Parse(r io.Reader) error {
+	br := bufio.NewReader(r)
+	if header, _ := br.Peek(len(snapshotMagic)); IsSnapshot(header) {
+		snapshot, err := ReadSnapshot(br)
+		if err != nil {
+			return err
+		}
+		snapshot.parser, snapshot.compact = b.parser, b.compact
+		*b = *snapshot
+		return nil
+	}
+
+	if pp, ok := b.parser.(BlocklistPrefixesParser); ok && b.compact {
+		prefixes, err := pp.ParsePrefixes(br)
+		if err != nil {
+			return err
+		}
+		b.ips, b.nets, b.prefixList = nil, nil, prefixes
+	} else {
+		ips, nets, err := b.parser.Parse(br)
+		if err != nil {
+			return err
+		}
+		b.ips, b.nets, b.prefixList = ips, nets, nil
+	}
+	b.records, b.recordsData = nil, nil
+	if rp, ok := b.parser.(BlocklistRecordsParser); ok {
+		b.records = rp.Records()
+	}
+	b.updated = ""
+	if up, ok := b.parser.(BlocklistUpdatedParser); ok {
+		b.updated = up.Updated()
+	}
+	b.domains = nil
+	if dp, ok := b.parser.(BlocklistDomainsParser); ok {
+		if domains := dp.Domains(); domains != nil {
+			b.domains = CompactDomains(domains)
+		}
+	}
+	return nil
+}
+
+// Заблокированные домены в нормализованном виде (см. CompactDomains). Если парсер не сохранял домены,
+// они берутся из исходных записей; nil, если нет ни того, ни другого.
+func (b *Blocklist) Domains() []string {
+	if b.domains == nil {
+		if records, err := b.Records(); err == nil && records != nil {
+			return records.Domains()
+		}
+	}
+	return b.domains
+}
+
+// Время формирования блоклиста в исходном виде (строка "Updated" дампа). Пустая строка, если оно неизвестно.
+func (b *Blocklist) Updated() string {
+	return b.updated
+}
+
+// Индекс исходных записей блоклиста. nil, если парсер не сохранял записи.
+// Записи снимка разбираются при первом обращении.
+func (b *Blocklist) Records() (*RecordIndex, error) {
+	if b.recordsData != nil {
+		records, err := readSnapshotRecords(b.recordsData)
+		if err != nil {
+			return nil, err
+		}
+		if b.recordsData.pos != len(b.recordsData.data) {
+			return nil, errors.New("unexpected data at the end of snapshot records")
+		}
+		b.records, b.recordsData = records, nil
+	}
+	return b.records, nil
+}
+
+// Обход префиксов блоклиста, отдельные адреса передаются префиксами /32
+func (b *Blocklist) eachPrefix(f func(p Prefix)) {
+	for _, p := range b.prefixList {
+		f(p)
+	}
+	for ip := range b.ips {
+		f(NewPrefix(uint32(ip), 32))
+	}
+	for _, n := range b.nets {
+		f(PrefixOf(n))
+	}
+}
+
+// Кол-во префиксов блоклиста
+func (b *Blocklist) prefixCount() int {
+	return len(b.prefixList) + len(b.ips) + len(b.nets)
+}
+
+// Установка парсера
+func (b *Blocklist) SetParser(p BlocklistParser) {
+	b.parser = p
+}
+
+// Режим компактного хранения для CompactTree: парсер с BlocklistPrefixesParser разбирает блоклист сразу в срез префиксов,
+// без множества отдельных адресов и net.IPNet на каждую сеть
+func (b *Blocklist) SetCompact(compact bool) {
+	b.compact = compact
+}
+
+// Формирование дерева подсетей из блоклиста.
+// Поддеревья каждого первого октета строятся параллельно и затем присоединяются к корню,
+// подсети с маской короче /8 добавляются в последнюю очередь.
+func (b *Blocklist) SubnetsTree() (root *IPTreeNode) {
+	var shardNets [256][]Prefix
+	var shardIPs [256][]ipv4range.IPv4
+	var wide []Prefix
+	b.eachPrefix(func(p Prefix) {
+		switch {
+		case p.Len < 8:
+			wide = append(wide, p)
+		case p.Len == 32:
+			shardIPs[p.Addr>>24] = append(shardIPs[p.Addr>>24], ipv4range.IPv4(p.Addr))
+		default:
+			shardNets[p.Addr>>24] = append(shardNets[p.Addr>>24], p)
+		}
+	})
+
+	// Построение поддеревьев в пуле потоков
+	var shards [256]*IPTreeNode
+	octets := make(chan int, 256)
+	for octet := 0; octet < 256; octet++ {
+		if len(shardNets[octet]) > 0 || len(shardIPs[octet]) > 0 {
+			octets <- octet
+		}
+	}
+	close(octets)
+	var wg sync.WaitGroup
+	for i := 0; i < runtime.GOMAXPROCS(0); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for octet := range octets {
+				shard := &IPTreeNode{}
+				for _, p := range shardNets[octet] {
+					shard.AddSubnet(p.IPNet())
+				}
+				for _, ip := range shardIPs[octet] {
+					shard.AddIP(ip)
+				}
+				shards[octet] = shard
+			}
+		}()
+	}
+	wg.Wait()
+
+	root = &IPTreeNode{}
+	for octet, shard := range shards {
+		if shard != nil {
+			root.graft(shard.find(NewPrefix(uint32(octet)<<24, 8)))
+		}
+	}
+	for _, p := range wide {
+		root.AddSubnet(p.IPNet())
+	}
+	return
+}
+
+// Префиксы всех записей блоклиста
+func (b *Blocklist) prefixes() map[Prefix]struct{} {
+	prefixes := make(map[Prefix]struct{}, b.prefixCount())
+	b.eachPrefix(func(p Prefix) {
+		prefixes[p] = struct{}{}
+	})
+	return prefixes
+}
+
+// Изменения относительно более нового блоклиста newer для IPTreeNode.ApplyDiff.
+// Удаление подсети задевает вложенные и содержащие её записи, поэтому оставшиеся в newer пересекающиеся записи
+// добавляются в added повторно. Поиск вложенных записей перебирает весь блоклист, но удаляются обычно отдельные адреса.
+func (b *Blocklist) Diff(newer *Blocklist) (added, removed []*net.IPNet) {
+	oldPrefixes, newPrefixes := b.prefixes(), newer.prefixes()
+
+	addedPrefixes := make(map[Prefix]struct{})
+	for p := range newPrefixes {
+		if _, ok := oldPrefixes[p]; !ok {
+			addedPrefixes[p] = struct{}{}
+		}
+	}
+	for p := range oldPrefixes {
+		if _, ok := newPrefixes[p]; ok {
+			continue
+		}
+		removed = append(removed, p.IPNet())
+
+		// записи, содержащие удаленную
+		for l := uint8(0); l < p.Len; l++ {
+			if _, ok := newPrefixes[p.Parent(l)]; ok {
+				addedPrefixes[p.Parent(l)] = struct{}{}
+			}
+		}
+		// записи, вложенные в удаленную
+		if p.Len < 32 {
+			for np := range newPrefixes {
+				if p.Contains(np) {
+					addedPrefixes[np] = struct{}{}
+				}
+			}
+		}
+	}
+
+	for p := range addedPrefixes {
+		added = append(added, p.IPNet())
+	}
+	return
+}
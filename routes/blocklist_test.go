@@ -0,0 +1,34 @@
+package routes
+
+import (
+	"runtime"
+	"testing"
+
+	"github.com/amkulikov/ipv4range"
+)
+
+// Параллельное построение дерева по октетам совпадает с последовательным добавлением записей
+func TestSubnetsTreeSequential(t *testing.T) {
+	// подсети короче /8 добавляются после присоединения поддеревьев, часть из них поглощает поддеревья
+	b := parseTestDump(t, testDump(5000)+testDenseDump(10, 500)[len("Updated: 2026-10-19 10:00:00 +0000\n"):]+
+		"0.0.0.0/6;wide1.example.com;;;;\n"+
+		"64.0.0.0/7 | 100.0.0.0/8;wide2.example.com;;;;\n"+
+		"200.0.0.0/5;wide3.example.com;;;;\n", false)
+
+	want := &IPTreeNode{}
+	b.eachPrefix(func(p Prefix) {
+		if p.Len == 32 {
+			want.AddIP(ipv4range.IPv4(p.Addr))
+		} else {
+			want.AddSubnet(p.IPNet())
+		}
+	})
+
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(0))
+	for _, procs := range []int{1, 2, 3, 8, 64} {
+		runtime.GOMAXPROCS(procs)
+		if d := diffTrees(b.SubnetsTree(), want); d != "" {
+			t.Errorf("GOMAXPROCS %d: %s", procs, d)
+		}
+	}
+}
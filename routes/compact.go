@@ -0,0 +1,323 @@
+package routes
+
+import (
+	"math/bits"
+	"net"
+	"sort"
+
+	"github.com/amkulikov/ipv4range"
+)
+
+// Отсутствующий узел сжатого дерева
+const compactNone int32 = -1
+
+// Узел сжатого дерева. Потомки хранятся индексами в общем срезе.
+type compactNode struct {
+	addr     uint32
+	size     uint32   // SubtreeSize
+	leafs    uint32   // SubtreeLeafsCount
+	children [2]int32 // потомки по следующему после префикса биту
+	len      uint8
+	leaf     bool
+	force    bool // ForceExpand
+}
+
+// Вместимость подсети узла. Для корня, как и у IPTreeNode, равна 0.
+func (n *compactNode) capacity() uint32 {
+	return uint32(uint64(1) << (32 - uint(n.len)))
+}
+
+// Расчёт штрафа, аналогичный IPTreeNode.Penalty
+func (n *compactNode) penalty() uint32 {
+	if n.force {
+		return ^uint32(0)
+	} else if n.leaf {
+		return 1
+	}
+	return n.capacity() / n.size * n.leafs
+}
+
+func (n *compactNode) prefix() Prefix {
+	return Prefix{Addr: n.addr, Len: n.len}
+}
+
+// Сжатое (Patricia) дерево подсетей. В отличие от IPTreeNode хранит только листья и узлы ветвления,
+// т.е. ровно те узлы, которые возвращает IPTreeNode.Fallthrough, без указателей на родителя и отдельного net.IP.
+// Счетчики поддеревьев и результаты оптимизации совпадают с IPTreeNode.
+type CompactTree struct {
+	nodes []compactNode // nodes[0] - корень 0.0.0.0/0
+}
+
+// Построение сжатого дерева из префиксов заблокированных адресов и подсетей
+func NewCompactTree(prefixes []Prefix) *CompactTree {
+	leaves := make([]Prefix, len(prefixes))
+	copy(leaves, prefixes)
+	return newCompactTree(disjointPrefixes(leaves))
+}
+
+// Упорядочивание префиксов на месте с удалением входящих в другие
+func disjointPrefixes(prefixes []Prefix) []Prefix {
+	sortPrefixes(prefixes)
+	// После сортировки содержащий префикс идет раньше вложенных
+	uniq := prefixes[:0]
+	for _, p := range prefixes {
+		if len(uniq) > 0 && uniq[len(uniq)-1].Contains(p) {
+			continue
+		}
+		uniq = append(uniq, p)
+	}
+	return uniq
+}
+
+// Построение сжатого дерева из отсортированных непересекающихся префиксов
+func newCompactTree(leaves []Prefix) *CompactTree {
+	c := &CompactTree{nodes: make([]compactNode, 1, 2*len(leaves))}
+	c.nodes[0].children = [2]int32{compactNone, compactNone}
+	split := sort.Search(len(leaves), func(i int) bool {
+		return leaves[i].Addr&(1<<31) != 0
+	})
+	c.nodes[0].children[0] = c.build(leaves[:split])
+	c.nodes[0].children[1] = c.build(leaves[split:])
+	c.updateCounters(0)
+	return c
+}
+
+// Построение сжатого дерева из блоклиста. Префиксы снимка и блоклиста, разобранного в режиме SetCompact,
+// упорядочиваются на месте без копирования, вложенные в другие префиксы при этом отбрасываются.
+func (b *Blocklist) CompactTree() *CompactTree {
+	if len(b.ips) == 0 && len(b.nets) == 0 {
+		b.prefixList = disjointPrefixes(b.prefixList)
+		return newCompactTree(b.prefixList)
+	}
+	prefixes := make([]Prefix, 0, b.prefixCount())
+	b.eachPrefix(func(p Prefix) {
+		prefixes = append(prefixes, p)
+	})
+	return newCompactTree(disjointPrefixes(prefixes))
+}
+
+// Построение поддерева из отсортированных непересекающихся префиксов
+func (c *CompactTree) build(leaves []Prefix) int32 {
+	switch len(leaves) {
+	case 0:
+		return compactNone
+	case 1:
+		return c.newLeaf(leaves[0])
+	}
+
+	// узел ветвления находится на длине общего префикса первого и последнего листа
+	first, last := leaves[0], leaves[len(leaves)-1]
+	length := uint8(bits.LeadingZeros32(first.Addr ^ last.Addr))
+	bit := uint32(1) << (31 - length)
+	split := sort.Search(len(leaves), func(i int) bool {
+		return leaves[i].Addr&bit != 0
+	})
+
+	i := c.newBranch(NewPrefix(first.Addr, length))
+	zero := c.build(leaves[:split])
+	one := c.build(leaves[split:])
+	c.nodes[i].children = [2]int32{zero, one}
+	c.updateCounters(i)
+	return i
+}
+
+func (c *CompactTree) newLeaf(p Prefix) int32 {
+	n := compactNode{addr: p.Addr, len: p.Len, leaf: true, leafs: 1, children: [2]int32{compactNone, compactNone}}
+	n.size = n.capacity()
+	c.nodes = append(c.nodes, n)
+	return int32(len(c.nodes) - 1)
+}
+
+func (c *CompactTree) newBranch(p Prefix) int32 {
+	c.nodes = append(c.nodes, compactNode{addr: p.Addr, len: p.Len, children: [2]int32{compactNone, compactNone}})
+	return int32(len(c.nodes) - 1)
+}
+
+// Пересчет счетчиков узла ветвления по потомкам
+func (c *CompactTree) updateCounters(i int32) {
+	n := &c.nodes[i]
+	n.size, n.leafs = 0, 0
+	for _, ch := range n.children {
+		if ch != compactNone {
+			n.size += c.nodes[ch].size
+			n.leafs += c.nodes[ch].leafs
+		}
+	}
+}
+
+// Кол-во заблокированных адресов
+func (c *CompactTree) Size() uint32 {
+	return c.nodes[0].size
+}
+
+// Кол-во листьев, т.е. непересекающихся заблокированных адресов и подсетей
+func (c *CompactTree) LeafsCount() uint32 {
+	return c.nodes[0].leafs
+}
+
+// Кол-во узлов в срезе, включая удаленные исключением
+func (c *CompactTree) NodesCount() int {
+	return len(c.nodes)
+}
+
+// Исключение подсети из результата, аналогичное IPTreeNode.ExcludeSubnet: подсеть удаляется,
+// а содержащие её узлы ветвления должны быть обязательно разбиты при оптимизации.
+func (c *CompactTree) ExcludeSubnet(s *net.IPNet) {
+	e := PrefixOf(s)
+	root := &c.nodes[0]
+	if e.Len >= 2 {
+		root.force = true
+	}
+	if e.Len == 0 {
+		root.children = [2]int32{compactNone, compactNone}
+	} else {
+		b := e.Addr >> 31
+		c.nodes[0].children[b] = c.exclude(c.nodes[0].children[b], e)
+	}
+	c.updateCounters(0)
+}
+
+// Исключение префикса e из поддерева i. Возвращает индекс узла, который должен занять место i.
+func (c *CompactTree) exclude(i int32, e Prefix) int32 {
+	if i == compactNone {
+		return i
+	}
+	p := c.nodes[i].prefix()
+	switch {
+	case e.Contains(p):
+		return compactNone
+	case !p.Contains(e):
+		// поддерево не пересекается с исключаемой подсетью
+		return i
+	case c.nodes[i].leaf:
+		return c.splitLeaf(p, e)
+	}
+
+	// IPTreeNode помечает узлы, из которых спуск к исключаемой подсети продолжается глубже её родителя
+	if p.Len+2 <= e.Len {
+		c.nodes[i].force = true
+	}
+	b := (e.Addr >> (31 - p.Len)) & 1
+	child := c.exclude(c.nodes[i].children[b], e)
+	c.nodes[i].children[b] = child
+	if child == compactNone {
+		// узел перестал ветвиться и заменяется оставшимся потомком
+		return c.nodes[i].children[1-b]
+	}
+	c.updateCounters(i)
+	return i
+}
+
+// Разделение листа p на части, оставшиеся после исключения вложенного префикса e.
+// Как и в IPTreeNode, лист делится пополам на каждом уровне до e, половины без e остаются листьями.
+func (c *CompactTree) splitLeaf(p, e Prefix) int32 {
+	cur := compactNone
+	for l := int(e.Len) - 1; l >= int(p.Len); l-- {
+		b := (e.Addr >> (31 - uint(l))) & 1
+		sibling := c.newLeaf(NewPrefix(e.Addr^(1<<(31-uint(l))), uint8(l+1)))
+		if cur == compactNone {
+			cur = sibling
+			continue
+		}
+		i := c.newBranch(e.Parent(uint8(l)))
+		c.nodes[i].children[b] = cur
+		c.nodes[i].children[1-b] = sibling
+		c.nodes[i].force = true
+		c.updateCounters(i)
+		cur = i
+	}
+	return cur
+}
+
+// Формирование маршрутов без прежних маршрутов и исключений, см. GetOptimizedRoutes.
+// Узлы маршрутов - отдельные IPTreeNode без потомков, но со счетчиками подсети.
+func (c *CompactTree) OptimizedRoutes(excludeNets []*net.IPNet, maxNets uint) []*Route {
+	return GetOptimizedRoutes(c, OptimizeOptions{ExcludeNets: excludeNets, MaxNets: maxNets})
+}
+
+func (c *CompactTree) optimizeRoot() optimizeNode {
+	return compactRef{c, 0}
+}
+
+// Ссылка на узел сжатого дерева для общих этапов оптимизации.
+// Узлы с одним потомком, которые есть в IPTreeNode, учитываются в подсчете незаполненных подсетей.
+type compactRef struct {
+	c *CompactTree
+	i int32
+}
+
+func (r compactRef) node() *compactNode { return &r.c.nodes[r.i] }
+
+func (r compactRef) Prefix() Prefix   { return r.node().prefix() }
+func (r compactRef) Penalty() uint32  { return r.node().penalty() }
+func (r compactRef) capacity() uint32 { return r.node().capacity() }
+func (r compactRef) size() uint32     { return r.node().size }
+func (r compactRef) leafs() uint32    { return r.node().leafs }
+func (r compactRef) leaf() bool       { return r.node().leaf }
+func (r compactRef) forced() bool     { return r.node().force }
+
+func (r compactRef) child(bit int) optimizeNode {
+	ch := r.node().children[bit]
+	if ch == compactNone {
+		return nil
+	}
+	return compactRef{r.c, ch}
+}
+
+// Узлы сжатого дерева, кроме корня, всегда ветвятся либо являются листьями
+func (r compactRef) fallthroughNode() optimizeNode { return r }
+
+func (r compactRef) routeNode() *IPTreeNode {
+	n := r.node()
+	node := NewIPTreeNode(ipv4range.IPv4(n.addr), n.len, nil)
+	node.IsLeaf = n.leaf
+	node.ForceExpand = n.force
+	node.SubtreeSize = n.size
+	node.SubtreeLeafsCount = n.leafs
+	return node
+}
+
+// Незаполненные подсети поддерева в том же порядке, что и IPTreeNode.Gaps
+func (r compactRef) Gaps() (gaps []*net.IPNet) {
+	n := r.node()
+	if n.leaf {
+		return nil
+	}
+	for bit, ch := range n.children {
+		if ch == compactNone {
+			gaps = append(gaps, NewPrefix(n.addr|uint32(bit)<<(31-n.len), n.len+1).IPNet())
+		} else {
+			gaps = append(gaps, compactRef{r.c, ch}.pathGaps(n.len+1)...)
+		}
+	}
+	return
+}
+
+// Незаполненные подсети пропущенных узлов на пути от длины l до узла и подсети самого узла
+func (r compactRef) pathGaps(l uint8) []*net.IPNet {
+	p := r.Prefix()
+	if l >= p.Len {
+		return r.Gaps()
+	}
+	sibling := NewPrefix(p.Addr^(1<<(31-l)), l+1).IPNet()
+	if p.Addr&(1<<(31-l)) != 0 {
+		return append([]*net.IPNet{sibling}, r.pathGaps(l+1)...)
+	}
+	return append(r.pathGaps(l+1), sibling)
+}
+
+// Кол-во незаполненных подсетей поддерева, как у IPTreeNode.GapsCount
+func (r compactRef) GapsCount() (count uint) {
+	n := r.node()
+	if n.leaf {
+		return 0
+	}
+	for _, ch := range n.children {
+		if ch == compactNone {
+			count++
+		} else {
+			count += uint(r.c.nodes[ch].len-n.len-1) + compactRef{r.c, ch}.GapsCount()
+		}
+	}
+	return
+}
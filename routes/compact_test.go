@@ -0,0 +1,117 @@
+package routes
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Синтетический дамп с почти заполненными подсетями /24, для которых выгодны маршруты с исключениями
+func testDenseDump(blocks, scattered int) string {
+	rnd := rand.New(rand.NewSource(2))
+	var sb strings.Builder
+	sb.WriteString("Updated: 2026-10-19 10:00:00 +0000\n")
+	line := 0
+	for i := 0; i < blocks; i++ {
+		a, b, c := 1+rnd.Intn(223), rnd.Intn(256), rnd.Intn(256)
+		for _, d := range rnd.Perm(256)[:200+rnd.Intn(56)] {
+			line++
+			fmt.Fprintf(&sb, "%d.%d.%d.%d;site%d.example.com;;;;\n", a, b, c, d, line)
+		}
+	}
+	for i := 0; i < scattered; i++ {
+		line++
+		fmt.Fprintf(&sb, "%d.%d.%d.%d;site%d.example.com;;;;\n", 1+rnd.Intn(223), rnd.Intn(256), rnd.Intn(256), rnd.Intn(256), line)
+	}
+	return sb.String()
+}
+
+func routeStrings(routes []*Route) []string {
+	s := make([]string, 0, len(routes))
+	for _, r := range routes {
+		s = append(s, fmt.Sprint(r.Network(), r.Exceptions, r.Covered()))
+	}
+	return s
+}
+
+func mustCIDRs(t *testing.T, cidrs ...string) (nets []*net.IPNet) {
+	for _, c := range cidrs {
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		nets = append(nets, n)
+	}
+	return
+}
+
+func TestCompactTreeOptimizedRoutes(t *testing.T) {
+	b := parseTestDump(t, testDump(3000)+testDenseDump(40, 3000)[len("Updated: 2026-10-19 10:00:00 +0000\n"):], false)
+
+	// прежние маршруты - результат по половине блоклиста
+	half := parseTestDump(t, testDump(1500), false)
+	prevNets := GetOptimizedNets(half.SubnetsTree(), nil, 1000)
+	excludes := []string{"10.0.0.0/8", "100.64.0.0/10", "192.168.1.0/24", "45.1.2.3/32"}
+
+	for _, tc := range []struct {
+		name string
+		opts OptimizeOptions
+	}{
+		{"max", OptimizeOptions{MaxNets: 1000}},
+		{"one", OptimizeOptions{MaxNets: 1}},
+		{"all", OptimizeOptions{MaxNets: 1 << 20}},
+		{"exceptions", OptimizeOptions{MaxNets: 2000, Exceptions: true}},
+		{"exceptions spare", OptimizeOptions{MaxNets: 14000, Exceptions: true}},
+		{"previous", OptimizeOptions{MaxNets: 1500, Exceptions: true, Previous: NewPreviousRoutes(prevNets, 0.1)}},
+	} {
+		for _, exclude := range []bool{false, true} {
+			opts := tc.opts
+			if exclude {
+				opts.ExcludeNets = mustCIDRs(t, excludes...)
+			}
+			want := routeStrings(GetOptimizedRoutes(b.SubnetsTree(), opts))
+			got := routeStrings(GetOptimizedRoutes(b.CompactTree(), opts))
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("%s, exclude %v: compact tree routes differ (%d vs %d)", tc.name, exclude, len(got), len(want))
+			}
+		}
+	}
+}
+
+func TestCompactTreeParetoCurve(t *testing.T) {
+	b := parseTestDump(t, testDenseDump(20, 2000), false)
+	excludes := mustCIDRs(t, "10.0.0.0/8")
+	want := ParetoCurve(b.SubnetsTree(), excludes)
+	got := ParetoCurve(b.CompactTree(), excludes)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("compact tree pareto curve differs: %d points vs %d", len(got), len(want))
+	}
+}
+
+// В режиме compact блоклист разбирается сразу в префиксы
+func TestBlocklistSetCompact(t *testing.T) {
+	dump := testDump(5000)
+	want := parseTestDump(t, dump, false)
+
+	b := NewBlocklist()
+	b.SetParser(&ZapretInfoParser{AllowEmptyDomain: true})
+	b.SetCompact(true)
+	if err := b.Parse(strings.NewReader(dump)); err != nil {
+		t.Fatal(err)
+	}
+	if len(b.ips) != 0 || len(b.nets) != 0 {
+		t.Errorf("compact blocklist keeps %d ips and %d nets", len(b.ips), len(b.nets))
+	}
+	if !reflect.DeepEqual(sortedPrefixes(b), sortedPrefixes(want)) {
+		t.Errorf("compact blocklist prefixes differ")
+	}
+
+	c, wantTree := b.CompactTree(), want.SubnetsTree()
+	if c.Size() != wantTree.SubtreeSize || c.LeafsCount() != wantTree.SubtreeLeafsCount {
+		t.Errorf("compact tree counters %d/%d, want %d/%d",
+			c.Size(), c.LeafsCount(), wantTree.SubtreeSize, wantTree.SubtreeLeafsCount)
+	}
+}
@@ -0,0 +1,4 @@
+// Пакет routes формирует оптимизированный набор маршрутов из списка заблокированных ресурсов:
+// разбор блоклиста, построение дерева подсетей, жадное объединение подсетей и вывод маршрутов в разных форматах.
+// Утилита blocked_routes является тонкой оберткой над этим пакетом.
+package routes
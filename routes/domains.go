@@ -0,0 +1,165 @@
+package routes
+
+import (
+	"sort"
+	"strings"
+)
+
+// Заблокированные домены исходных записей в нормализованном виде (см. CompactDomains)
+func (ri *RecordIndex) Domains() []string {
+	seen := make(map[*Record]struct{})
+	var domains []string
+	for _, rr := range ri.records {
+		for _, r := range rr {
+			if _, ok := seen[r]; ok {
+				continue
+			}
+			seen[r] = struct{}{}
+			if r.Domain != "" {
+				domains = append(domains, r.Domain)
+			}
+		}
+	}
+	return CompactDomains(domains)
+}
+
+// Нормализация списка доменов: нижний регистр, punycode для национальных доменов, без "*." и точки в конце.
+// Некорректные домены отбрасываются, поддомены уже перечисленных доменов удаляются.
+// Результат упорядочен по алфавиту.
+func CompactDomains(domains []string) []string {
+	set := make(map[string]struct{}, len(domains))
+	for _, d := range domains {
+		if d = NormalizeDomain(d); d != "" {
+			set[d] = struct{}{}
+		}
+	}
+
+	result := make([]string, 0, len(set))
+	for d := range set {
+		parent := d
+		covered := false
+		for i := strings.IndexByte(parent, '.'); i >= 0; i = strings.IndexByte(parent, '.') {
+			parent = parent[i+1:]
+			if _, ok := set[parent]; ok {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			result = append(result, d)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+// Нормализация домена для сравнения по суффиксу. Возвращает пустую строку для некорректного домена.
+func NormalizeDomain(d string) string {
+	d = strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(d), "*."), ".")
+	if d == "" {
+		return ""
+	}
+	labels := strings.Split(strings.ToLower(d), ".")
+	for i, l := range labels {
+		if l == "" {
+			return ""
+		}
+		for _, c := range l {
+			if c >= 0x80 {
+				l = punycode(l)
+				break
+			}
+			if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+				return ""
+			}
+		}
+		labels[i] = l
+	}
+	return strings.Join(labels, ".")
+}
+
+// Кодирование метки домена в punycode (RFC 3492) с префиксом xn--
+func punycode(label string) string {
+	const (
+		base        = 36
+		tmin        = 1
+		tmax        = 26
+		initialBias = 72
+		initialN    = 128
+	)
+	runes := []rune(label)
+	var out []byte
+	for _, r := range runes {
+		if r < 0x80 {
+			out = append(out, byte(r))
+		}
+	}
+	b := len(out)
+	h := b
+	if b > 0 {
+		out = append(out, '-')
+	}
+
+	n, delta, bias := rune(initialN), 0, initialBias
+	for h < len(runes) {
+		m := rune(0x7fffffff)
+		for _, r := range runes {
+			if r >= n && r < m {
+				m = r
+			}
+		}
+		delta += int(m-n) * (h + 1)
+		n = m
+		for _, r := range runes {
+			if r < n {
+				delta++
+			}
+			if r != n {
+				continue
+			}
+			q := delta
+			for k := base; ; k += base {
+				t := k - bias
+				if t < tmin {
+					t = tmin
+				} else if t > tmax {
+					t = tmax
+				}
+				if q < t {
+					break
+				}
+				out = append(out, punycodeDigit(t+(q-t)%(base-t)))
+				q = (q - t) / (base - t)
+			}
+			out = append(out, punycodeDigit(q))
+			bias = punycodeAdapt(delta, h+1, h == b)
+			delta = 0
+			h++
+		}
+		delta++
+		n++
+	}
+	return "xn--" + string(out)
+}
+
+func punycodeDigit(d int) byte {
+	if d < 26 {
+		return byte('a' + d)
+	}
+	return byte('0' + d - 26)
+}
+
+func punycodeAdapt(delta, points int, first bool) int {
+	if first {
+		delta /= 700
+	} else {
+		delta /= 2
+	}
+	delta += delta / points
+	k := 0
+	for delta > (36-1)*26/2 {
+		delta /= 36 - 1
+		k += 36
+	}
+	return k + 36*delta/(delta+38)
+}
@@ -1,4 +1,4 @@
-package main
+package routes
 
 import (
 	"net"
@@ -14,6 +14,11 @@ var (
 	_, privateNet16, _ = net.ParseCIDR("192.168.0.0/16")
 )
 
+// Частные сети, которые всегда исключаются из маршрутов
+func PrivateNets() []*net.IPNet {
+	return []*net.IPNet{privateNet8, privateNet12, privateNet16}
+}
+
 // Загрузка списка исключаемых подсетей.
 // src может быть путем к файлу с подсетями, разделенными переносом строки, либо строкой, где подсети разделены запятой.
 func LoadExcludedNets(src string) ([]*net.IPNet, error) {
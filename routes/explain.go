@@ -0,0 +1,146 @@
+package routes
+
+import (
+	"fmt"
+	"io"
+	"sort"
+)
+
+// Объяснение итогового маршрута: что в него попало и почему оптимизация не разбила его дальше
+type Explanation struct {
+	Prefix       string    `json:"prefix"`
+	Exceptions   []string  `json:"exceptions,omitempty"`
+	Blocked      uint32    `json:"blocked"`    // заблокированные адреса в маршруте
+	Collateral   uint32    `json:"collateral"` // незаблокированные адреса в маршруте
+	Penalty      uint32    `json:"penalty"`
+	Cutoff       uint32    `json:"cutoff"` // наибольший штраф среди итоговых маршрутов: следующий на разбиение
+	Reason       string    `json:"reason"`
+	EntriesTotal int       `json:"entries_total"`
+	Entries      []string  `json:"entries"` // заблокированные адреса и подсети внутри маршрута
+	Domains      []Counter `json:"domains"`
+	Orgs         []Counter `json:"orgs"`
+	RecordsTotal int       `json:"records_total"` // записи блоклиста внутри маршрута
+}
+
+// Значение и кол-во записей с ним
+type Counter struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+// Объяснение маршрута route из итогового набора rs. records может быть nil, тогда домены и органы не заполняются.
+// В Entries, Domains и Orgs попадает не более limit элементов.
+func Explain(route *Route, rs []*Route, records *RecordIndex, limit int) *Explanation {
+	node := route.Node
+	e := &Explanation{
+		Prefix:  route.Network().String(),
+		Blocked: node.SubtreeSize,
+		Penalty: node.Penalty(),
+	}
+
+	covered := node.SubtreeCapacity
+	for _, ex := range route.Exceptions {
+		ones, bits := ex.Mask.Size()
+		covered -= 1 << uint(bits-ones)
+		e.Exceptions = append(e.Exceptions, ex.String())
+	}
+	e.Collateral = covered - node.SubtreeSize
+
+	for _, r := range rs {
+		if !r.Node.IsLeaf && r.Node.Penalty() > e.Cutoff {
+			e.Cutoff = r.Node.Penalty()
+		}
+	}
+
+	switch {
+	case node.IsLeaf:
+		e.Reason = "single blocked entry, nothing to split"
+	case len(route.Exceptions) > 0:
+		e.Reason = "covered with net_gateway exceptions, which is cheaper than separate routes"
+	case e.Penalty >= e.Cutoff:
+		e.Reason = "next in line for splitting, the route budget (-max) was exhausted before it"
+	default:
+		e.Reason = fmt.Sprintf("penalty %d is below the cut-off %d, other routes were split first", e.Penalty, e.Cutoff)
+	}
+
+	leaves := node.Leaves()
+	e.EntriesTotal = len(leaves)
+	for i, l := range leaves {
+		if i == limit {
+			break
+		}
+		e.Entries = append(e.Entries, l.Network().String())
+	}
+
+	if records != nil {
+		recs := records.Within(node.Prefix())
+		e.RecordsTotal = len(recs)
+		domains, orgs := make(map[string]int), make(map[string]int)
+		for _, rec := range recs {
+			if rec.Domain != "" {
+				domains[rec.Domain]++
+			}
+			if rec.Org != "" {
+				orgs[rec.Org]++
+			}
+		}
+		e.Domains = topCounters(domains, limit)
+		e.Orgs = topCounters(orgs, limit)
+	}
+	return e
+}
+
+// Самые частые значения, не более limit
+func topCounters(m map[string]int, limit int) []Counter {
+	counters := make([]Counter, 0, len(m))
+	for v, c := range m {
+		counters = append(counters, Counter{Value: v, Count: c})
+	}
+	sort.Slice(counters, func(i, j int) bool {
+		if counters[i].Count != counters[j].Count {
+			return counters[i].Count > counters[j].Count
+		}
+		return counters[i].Value < counters[j].Value
+	})
+	if limit < 0 {
+		limit = 0
+	}
+	if len(counters) > limit {
+		counters = counters[:limit]
+	}
+	return counters
+}
+
+// Вывод объяснения в читаемом виде
+func (e *Explanation) WriteText(w io.Writer) error {
+	var ratio float64
+	if total := e.Blocked + e.Collateral; total > 0 {
+		ratio = float64(e.Collateral) / float64(total) * 100
+	}
+	fmt.Fprintf(w, "Route %s\n", e.Prefix)
+	for _, ex := range e.Exceptions {
+		fmt.Fprintf(w, "  exception: %s\n", ex)
+	}
+	fmt.Fprintf(w, "  blocked addresses: %d, collateral: %d (%.1f%%)\n", e.Blocked, e.Collateral, ratio)
+	fmt.Fprintf(w, "  penalty: %d, cut-off: %d\n", e.Penalty, e.Cutoff)
+	fmt.Fprintf(w, "  reason: %s\n", e.Reason)
+
+	fmt.Fprintf(w, "  entries (%d of %d):\n", len(e.Entries), e.EntriesTotal)
+	for _, entry := range e.Entries {
+		fmt.Fprintf(w, "    %s\n", entry)
+	}
+	if len(e.Domains) > 0 {
+		fmt.Fprintf(w, "  top domains:\n")
+		for _, c := range e.Domains {
+			fmt.Fprintf(w, "    %-40s %d\n", c.Value, c.Count)
+		}
+	}
+	if len(e.Orgs) > 0 {
+		fmt.Fprintf(w, "  top organisations:\n")
+		for _, c := range e.Orgs {
+			fmt.Fprintf(w, "    %-40s %d\n", c.Value, c.Count)
+		}
+	}
+	_, err := fmt.Fprintf(w, "  records: %d\n", e.RecordsTotal)
+	return err
+}
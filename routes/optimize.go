@@ -0,0 +1,318 @@
+package routes
+
+import (
+	"net"
+	"sort"
+)
+
+// Узел дерева подсетей на общих этапах оптимизации: *IPTreeNode либо узел CompactTree
+type optimizeNode interface {
+	Prefix() Prefix
+	Penalty() uint32
+	Gaps() []*net.IPNet
+	GapsCount() uint
+
+	capacity() uint32              // SubtreeCapacity
+	size() uint32                  // SubtreeSize
+	leafs() uint32                 // SubtreeLeafsCount
+	leaf() bool                    // IsLeaf
+	forced() bool                  // ForceExpand
+	child(bit int) optimizeNode    // потомок по следующему после префикса биту, nil если его нет
+	fallthroughNode() optimizeNode // см. IPTreeNode.Fallthrough
+	routeNode() *IPTreeNode        // узел для Route
+}
+
+// Дерево подсетей, по которому формируются маршруты: корень IPTreeNode либо CompactTree
+type OptimizeTree interface {
+	ExcludeSubnet(s *net.IPNet)
+	optimizeRoot() optimizeNode
+}
+
+func (t *IPTreeNode) optimizeRoot() optimizeNode { return t }
+
+func (t *IPTreeNode) capacity() uint32 { return t.SubtreeCapacity }
+func (t *IPTreeNode) size() uint32     { return t.SubtreeSize }
+func (t *IPTreeNode) leafs() uint32    { return t.SubtreeLeafsCount }
+func (t *IPTreeNode) leaf() bool       { return t.IsLeaf }
+func (t *IPTreeNode) forced() bool     { return t.ForceExpand }
+
+func (t *IPTreeNode) child(bit int) optimizeNode {
+	c := t.Zero
+	if bit == 1 {
+		c = t.One
+	}
+	if c == nil {
+		return nil
+	}
+	return c
+}
+
+func (t *IPTreeNode) fallthroughNode() optimizeNode { return t.Fallthrough() }
+func (t *IPTreeNode) routeNode() *IPTreeNode        { return t }
+
+type IPTreeNodesList struct {
+	nodes      []optimizeNode
+	m          map[optimizeNode]struct{}
+	collateral uint64 // кол-во незаблокированных адресов во всех подсетях списка
+}
+
+func NewIPTreeNodesList(max uint32) *IPTreeNodesList {
+	return &IPTreeNodesList{
+		nodes: make([]optimizeNode, 0, max),
+		m:     make(map[optimizeNode]struct{}),
+	}
+}
+
+func (l *IPTreeNodesList) Insert(node *IPTreeNode) {
+	l.insert(node)
+}
+
+func (l *IPTreeNodesList) insert(node optimizeNode) {
+	// список отсортирован по размеру штрафа по убыванию, равные штрафы - в порядке добавления
+	penalty := node.Penalty()
+	i := sort.Search(len(l.nodes), func(i int) bool {
+		return l.nodes[i].Penalty() < penalty
+	})
+	l.nodes = append(l.nodes, nil)
+	copy(l.nodes[i+1:], l.nodes[i:])
+	l.nodes[i] = node
+	l.m[node] = struct{}{}
+	l.collateral += uint64(node.capacity() - node.size())
+}
+
+func (l *IPTreeNodesList) Nets() (nets []*net.IPNet) {
+	nets = make([]*net.IPNet, 0, l.Size())
+
+	for _, leaf := range l.nodes {
+		nets = append(nets, leaf.routeNode().Network())
+	}
+	return
+}
+
+func (l *IPTreeNodesList) Pop() (node *IPTreeNode) {
+	return l.PopAt(0)
+}
+
+// Извлечение подсети из i-й позиции списка
+func (l *IPTreeNodesList) PopAt(i int) (node *IPTreeNode) {
+	if n := l.popAt(i); n != nil {
+		return n.routeNode()
+	}
+	return nil
+}
+
+func (l *IPTreeNodesList) popAt(i int) (node optimizeNode) {
+	if len(l.nodes) <= i {
+		return nil
+	}
+	node = l.nodes[i]
+	delete(l.m, node)
+	l.collateral -= uint64(node.capacity() - node.size())
+	copy(l.nodes[i:], l.nodes[i+1:])
+	l.nodes[len(l.nodes)-1] = nil
+	l.nodes = l.nodes[:len(l.nodes)-1]
+	return
+}
+
+func (l *IPTreeNodesList) Size() uint {
+	return uint(len(l.nodes))
+}
+
+// Кол-во незаблокированных адресов во всех подсетях списка
+func (l *IPTreeNodesList) Collateral() uint64 {
+	return l.collateral
+}
+
+// Маршрут в итоговом списке
+type Route struct {
+	Node       *IPTreeNode  // подсеть маршрута
+	Exceptions []*net.IPNet // вложенные подсети, направляемые мимо туннеля (net_gateway)
+}
+
+// Подсеть маршрута
+func (r *Route) Network() *net.IPNet {
+	return r.Node.Network()
+}
+
+// Кол-во адресов, направляемых маршрутом: подсеть без исключений.
+// Исключения маршрута не содержат заблокированных адресов.
+func (r *Route) Covered() uint32 {
+	covered := r.Node.SubtreeCapacity
+	for _, e := range r.Exceptions {
+		ones, bits := e.Mask.Size()
+		covered -= 1 << uint(bits-ones)
+	}
+	return covered
+}
+
+// Параметры оптимизации
+type OptimizeOptions struct {
+	ExcludeNets []*net.IPNet    // исключаемые подсети
+	MaxNets     uint            // максимальное число маршрутов, включая исключения
+	Exceptions  bool            // разрешить маршруты с исключениями (net_gateway)
+	Previous    *PreviousRoutes // маршруты предыдущего запуска, которые желательно сохранить
+}
+
+func GetOptimizedNets(tree OptimizeTree, excludeNets []*net.IPNet, maxNets uint) (nets []*net.IPNet) {
+	return expandNodes(tree, excludeNets, nil, maxBudget(maxNets)).Nets()
+}
+
+// Формирование маршрутов по дереву подсетей: корню IPTreeNode либо CompactTree.
+// При включенных исключениях крупная подсеть с "дырами" заменяет набор мелких подсетей,
+// если это сокращает число маршрутов, а оставшийся запас маршрутов тратится на исключения, убирающие лишние адреса.
+func GetOptimizedRoutes(tree OptimizeTree, opts OptimizeOptions) (routes []*Route) {
+	l := expandNodes(tree, opts.ExcludeNets, opts.Previous, maxBudget(opts.MaxNets))
+	if !opts.Exceptions {
+		routes = make([]*Route, 0, l.Size())
+		for _, node := range l.nodes {
+			routes = append(routes, &Route{Node: node.routeNode()})
+		}
+		return
+	}
+
+	candidates, total, _, _ := collapseRoutes(tree.optimizeRoot(), l.m)
+	if total < opts.MaxNets {
+		punchExceptions(candidates, opts.MaxNets-total)
+	}
+	routes = make([]*Route, 0, len(candidates))
+	for _, c := range candidates {
+		routes = append(routes, &Route{Node: c.node.routeNode(), Exceptions: c.exceptions})
+	}
+	return
+}
+
+// Условие остановки разбиения по достижении maxNets подсетей
+func maxBudget(maxNets uint) func(l *IPTreeNodesList) bool {
+	return func(l *IPTreeNodesList) bool {
+		return l.Size() >= maxNets
+	}
+}
+
+// Жадное разбиение подсетей с наибольшим штрафом. Если заданы прежние маршруты prev, из подсетей с близким штрафом
+// предпочтение отдается тем, разбиение которых сохраняет прежний набор маршрутов.
+// stop вызывается для каждого состояния списка, на котором разбиение может быть остановлено, и прекращает его,
+// вернув true.
+func expandNodes(tree OptimizeTree, excludeNets []*net.IPNet, prev *PreviousRoutes, stop func(l *IPTreeNodesList) bool) *IPTreeNodesList {
+	rootNode := tree.optimizeRoot()
+	l := NewIPTreeNodesList(rootNode.leafs())
+
+	for _, e := range excludeNets {
+		tree.ExcludeSubnet(e)
+	}
+
+	l.insert(rootNode)
+
+	curNode := prev.pop(l)
+	for curNode != nil {
+		if curNode.Prefix().Len == 32 || curNode.leaf() {
+			l.insert(curNode)
+			break
+		}
+
+		for bit := 0; bit < 2; bit++ {
+			if child := curNode.child(bit); child != nil {
+				l.insert(child.fallthroughNode())
+			}
+		}
+
+		if !curNode.forced() && stop(l) {
+			break
+		}
+		curNode = prev.pop(l)
+	}
+
+	/*if maxNets > 0 {
+		for i := 0; i < len(l.nodes); i++ {
+			sibl := l.nodes[i].Sibling()
+			if _, ok := l.m[sibl]; ok {
+				delete(l.m, sibl)
+				delete(l.m, l.nodes[i])
+				for j := i + 1; j < len(l.nodes); j++ {
+					if l.nodes[j] == sibl {
+						l.nodes[j] = l.nodes[len(l.nodes)-1]
+						l.nodes[len(l.nodes)-1] = nil
+						l.nodes = l.nodes[:len(l.nodes)-1]
+						break
+					}
+				}
+				l.nodes[i] = l.nodes[i].Parent
+				i--
+			}
+		}
+	}*/
+
+	/*for _, n := range l.nodes {
+		fmt.Println(n.DumpNode(0))
+	}
+	fmt.Println(len(l.nodes))*/
+
+	return l
+}
+
+// Маршрут на этапе выбора исключений
+type routeCandidate struct {
+	node       optimizeNode
+	exceptions []*net.IPNet
+}
+
+// Замена выбранных подсетей поддерева t одним маршрутом с исключениями, если так получается меньше маршрутов,
+// либо столько же маршрутов, но без лишних адресов.
+// Возвращает маршруты поддерева, их число вместе с исключениями, кол-во незаполненных подсетей в поддереве
+// и кол-во лишних (незаблокированных) адресов в маршрутах.
+func collapseRoutes(t optimizeNode, selected map[optimizeNode]struct{}) (routes []*routeCandidate, count uint, gaps uint, collateral uint32) {
+	if _, ok := selected[t]; ok {
+		return []*routeCandidate{{node: t}}, 1, t.GapsCount(), t.capacity() - t.size()
+	}
+
+	for bit := 0; bit < 2; bit++ {
+		child := t.child(bit)
+		if child == nil {
+			if !t.leaf() {
+				gaps++
+			}
+			continue
+		}
+		r, c, g, cl := collapseRoutes(child, selected)
+		routes = append(routes, r...)
+		count += c
+		// CompactTree пропускает узлы с одним потомком: у каждого из них одна незаполненная подсеть.
+		// Такие узлы никогда не выгодно делать маршрутом, поэтому достаточно учесть их подсети.
+		gaps += g + uint(child.Prefix().Len-t.Prefix().Len-1)
+		collateral += cl
+	}
+
+	// корень дерева (0.0.0.0/0) маршрутом не делаем
+	if t.Prefix().Len > 0 && (1+gaps < count || 1+gaps == count && collateral > 0) {
+		return []*routeCandidate{{node: t, exceptions: t.Gaps()}}, 1 + gaps, gaps, 0
+	}
+	return
+}
+
+// Добавление исключений в маршруты без них, пока хватает запаса spare.
+// В первую очередь исключения получают маршруты, где на одно исключение приходится больше всего лишних адресов.
+func punchExceptions(routes []*routeCandidate, spare uint) {
+	type candidate struct {
+		route *routeCandidate
+		gaps  uint
+		gain  uint32
+	}
+	candidates := make([]candidate, 0)
+	for _, r := range routes {
+		if r.exceptions != nil || r.node.leaf() {
+			continue
+		}
+		if g := r.node.GapsCount(); g > 0 {
+			candidates = append(candidates, candidate{r, g, (r.node.capacity() - r.node.size()) / uint32(g)})
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].gain > candidates[j].gain
+	})
+
+	for _, c := range candidates {
+		if c.gaps <= spare {
+			c.route.exceptions = c.route.node.Gaps()
+			spare -= c.gaps
+		}
+	}
+}
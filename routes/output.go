@@ -0,0 +1,133 @@
+package routes
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"text/template"
+)
+
+// Параметры вывода маршрутов
+type OutputOptions struct {
+	Format string // формат вывода: default, cidr, ovpn, push-ovpn, wireguard, wg-quick, bird, bird2,
+	// routeros-address-list, routeros-route, ipset, nft, iproute2, template, json, yaml, pac,
+	// dnsmasq-ipset, dnsmasq-nftset, dnsmasq-server, unbound, sing-box, xray, clash
+
+	WireGuard WireGuardOptions // параметры форматов wireguard и wg-quick
+	Bird      BirdOptions      // параметры форматов bird и bird2
+	RouterOS  RouterOSOptions  // параметры форматов routeros-address-list и routeros-route
+	Set       SetOptions       // параметры форматов ipset и nft, а также наборов dnsmasq
+	IPRoute2  IPRoute2Options  // параметры формата iproute2
+	PAC       PACOptions       // параметры формата pac
+	DNS       DNSOptions       // параметры форматов dnsmasq и unbound
+	Proxy     ProxyOptions     // параметры форматов sing-box, xray и clash
+
+	Template *template.Template // пользовательский шаблон формата template (см. ParseTemplateFile)
+	Meta     OutputMeta         // сведения о запуске для шаблона и форматов json и yaml
+	Report   *Report            // отчет для форматов json и yaml, по умолчанию строится по маршрутам без исключенных адресов
+
+	Previous *PreviousRoutes // маршруты предыдущего запуска для форматов, выводящих изменения
+	Domains  []string        // заблокированные домены для форматов, использующих домены (см. FormatUsesDomains)
+}
+
+// Вывод маршрутов в w в заданном формате
+func OutputNets(w io.Writer, routes []*Route, opts OutputOptions) error {
+	bw := bufio.NewWriter(w)
+	var err error
+	switch opts.Format {
+	case "wireguard", "wg-quick":
+		err = outputWireGuard(bw, routes, opts)
+	case "bird", "bird2":
+		err = outputBird(bw, routes, opts)
+	case "routeros-address-list", "routeros-route":
+		err = outputRouterOS(bw, routes, opts)
+	case "ipset":
+		err = outputIPSet(bw, routes, opts)
+	case "nft":
+		err = outputNft(bw, routes, opts)
+	case "iproute2":
+		err = outputIPRoute2(bw, routes, opts)
+	case "pac":
+		err = outputPAC(bw, routes, opts)
+	case "dnsmasq-ipset", "dnsmasq-nftset", "dnsmasq-server":
+		err = outputDnsmasq(bw, opts)
+	case "unbound":
+		err = outputUnbound(bw, opts)
+	case "sing-box", "xray", "clash":
+		err = outputProxy(bw, routes, opts)
+	case "json":
+		err = outputJSON(bw, routes, opts)
+	case "yaml":
+		err = outputYAML(bw, routes, opts)
+	case "template":
+		if opts.Template == nil {
+			return errors.New("template format requires a template")
+		}
+		err = outputTemplate(bw, routes, opts)
+	default:
+		// формат проверяется до вывода, чтобы ошибка не зависела от наличия маршрутов
+		if !KnownFormat(opts.Format) {
+			return fmt.Errorf("unknown output format %q", opts.Format)
+		}
+		outputPlain(bw, routes, opts.Format)
+	}
+	if err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// Вывод подсетей по одной в строке: форматы default, cidr, ovpn и push-ovpn
+func outputPlain(w io.Writer, routes []*Route, format string) {
+	for _, r := range routes {
+		n := r.Network()
+		switch format {
+		case "cidr":
+			fmt.Fprintf(w, "%s\n", n)
+		case "ovpn":
+			fmt.Fprintf(w, "route %s %s\n", n.IP, net.IP(n.Mask))
+			for _, e := range r.Exceptions {
+				fmt.Fprintf(w, "route %s %s net_gateway\n", e.IP, net.IP(e.Mask))
+			}
+		case "push-ovpn":
+			fmt.Fprintf(w, "push \"route %s %s\"\n", n.IP, net.IP(n.Mask))
+			for _, e := range r.Exceptions {
+				fmt.Fprintf(w, "push \"route %s %s net_gateway\"\n", e.IP, net.IP(e.Mask))
+			}
+		default:
+			fmt.Fprintf(w, "%s %s\n", n.IP, net.IP(n.Mask))
+		}
+	}
+}
+
+// Поддерживается ли формат вывода
+func KnownFormat(format string) bool {
+	switch format {
+	case "default", "", "cidr", "ovpn", "push-ovpn", "wireguard", "wg-quick", "bird", "bird2",
+		"routeros-address-list", "routeros-route", "ipset", "nft", "iproute2", "template", "json", "yaml", "pac",
+		"dnsmasq-ipset", "dnsmasq-nftset", "dnsmasq-server", "unbound", "sing-box", "xray", "clash":
+		return true
+	}
+	return false
+}
+
+// Поддерживает ли формат вывода маршруты с исключениями
+func FormatSupportsExceptions(format string) bool {
+	switch format {
+	case "ovpn", "push-ovpn", "ipset", "nft", "iproute2", "template", "json", "yaml", "pac",
+		"sing-box", "xray", "clash":
+		return true
+	}
+	return false
+}
+
+// Использует ли формат вывода заблокированные домены (OutputOptions.Domains)
+func FormatUsesDomains(format string) bool {
+	switch format {
+	case "pac", "dnsmasq-ipset", "dnsmasq-nftset", "dnsmasq-server", "unbound", "sing-box", "xray", "clash":
+		return true
+	}
+	return false
+}
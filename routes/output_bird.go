@@ -0,0 +1,97 @@
+package routes
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Допустимое имя протокола BIRD
+var regexpBirdSymbol = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Параметры форматов bird и bird2
+type BirdOptions struct {
+	Protocol    string   // имя протокола static, по умолчанию blocked_routes
+	NextHop     string   // адрес следующего узла маршрутов
+	Recursive   bool     // рекурсивные маршруты: next hop ищется по таблице маршрутизации
+	Communities []string // BGP community в виде "asn:value", добавляемые маршрутам
+}
+
+// Вывод маршрутов в виде протокола static для BIRD 1.x (bird) или BIRD 2 (bird2).
+// Вывод содержит только блок протокола, поэтому его можно подключить в bird.conf через include
+// и применять изменения командой birdc configure.
+func outputBird(w io.Writer, routes []*Route, opts OutputOptions) error {
+	bo := opts.Bird
+	protocol := bo.Protocol
+	if protocol == "" {
+		protocol = "blocked_routes"
+	}
+	if !regexpBirdSymbol.MatchString(protocol) {
+		return fmt.Errorf("invalid BIRD protocol name %q", protocol)
+	}
+	gw := net.ParseIP(bo.NextHop).To4()
+	if gw == nil {
+		return errors.New("BIRD format requires IPv4 next hop")
+	}
+	communities, err := birdCommunities(bo.Communities)
+	if err != nil {
+		return err
+	}
+
+	via := "via " + gw.String()
+	if bo.Recursive {
+		via = "recursive " + gw.String()
+	}
+
+	fmt.Fprintf(w, "protocol static %s {\n", protocol)
+	indent := "\t"
+	if opts.Format == "bird2" {
+		if len(communities) == 0 {
+			fmt.Fprint(w, "\tipv4;\n")
+		} else {
+			fmt.Fprint(w, "\tipv4 {\n")
+			indent = "\t\t"
+		}
+	}
+	if len(communities) > 0 {
+		fmt.Fprintf(w, "%simport filter {\n", indent)
+		for _, c := range communities {
+			fmt.Fprintf(w, "%s\tbgp_community.add(%s);\n", indent, c)
+		}
+		fmt.Fprintf(w, "%s\taccept;\n%s};\n", indent, indent)
+		if opts.Format == "bird2" {
+			fmt.Fprint(w, "\t};\n")
+		}
+	}
+
+	for _, r := range routes {
+		if len(r.Exceptions) > 0 {
+			return errors.New("BIRD format doesn't support route exceptions")
+		}
+		fmt.Fprintf(w, "\troute %s %s;\n", r.Network(), via)
+	}
+	_, err = fmt.Fprint(w, "}\n")
+	return err
+}
+
+// Преобразование community "asn:value" в запись BIRD "(asn,value)"
+func birdCommunities(communities []string) (res []string, err error) {
+	for _, c := range communities {
+		parts := strings.Split(strings.TrimSpace(c), ":")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid BGP community %q", c)
+		}
+		var values [2]uint64
+		for i, p := range parts {
+			if values[i], err = strconv.ParseUint(p, 10, 16); err != nil {
+				return nil, fmt.Errorf("invalid BGP community %q", c)
+			}
+		}
+		res = append(res, fmt.Sprintf("(%d,%d)", values[0], values[1]))
+	}
+	return res, nil
+}
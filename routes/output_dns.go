@@ -0,0 +1,108 @@
+package routes
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Допустимый тип local-zone unbound
+var regexpUnboundZoneType = regexp.MustCompile(`^[a-z_]+$`)
+
+// Параметры форматов dnsmasq и unbound
+type DNSOptions struct {
+	Server   string // DNS-сервер для заблокированных доменов: адрес, адрес#порт либо адрес@порт
+	ZoneType string // тип local-zone unbound без Server, по умолчанию always_nxdomain
+}
+
+// Адрес и порт DNS-сервера. Порт 0, если он не указан.
+func (do DNSOptions) server() (net.IP, int, error) {
+	host, port := do.Server, 0
+	if i := strings.LastIndexAny(host, "#@"); i >= 0 {
+		p, err := strconv.Atoi(host[i+1:])
+		if err != nil || p <= 0 || p > 65535 {
+			return nil, 0, fmt.Errorf("invalid DNS server port in %q", do.Server)
+		}
+		host, port = host[:i], p
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil, 0, fmt.Errorf("invalid DNS server %q", do.Server)
+	}
+	return ip, port, nil
+}
+
+// Вывод конфигурации dnsmasq для доменов opts.Domains. Форматы dnsmasq-ipset и dnsmasq-nftset добавляют
+// адреса доменов в набор ipset или nftables при разрешении имени, dnsmasq-server направляет запросы
+// доменов на DNS-сервер Server. При заданном Server строки server= выводятся и для наборов.
+func outputDnsmasq(w io.Writer, opts OutputOptions) error {
+	so := opts.Set.withDefaults()
+	for _, name := range []string{so.Name, so.NftTable, so.NftFamily} {
+		if !regexpSetName.MatchString(name) {
+			return fmt.Errorf("invalid set name %q", name)
+		}
+	}
+
+	server := ""
+	if opts.DNS.Server != "" {
+		ip, port, err := opts.DNS.server()
+		if err != nil {
+			return err
+		}
+		server = ip.String()
+		if port > 0 {
+			server += "#" + strconv.Itoa(port)
+		}
+	} else if opts.Format == "dnsmasq-server" {
+		return errors.New("dnsmasq-server format requires DNS server")
+	}
+
+	for _, d := range opts.Domains {
+		switch opts.Format {
+		case "dnsmasq-ipset":
+			fmt.Fprintf(w, "ipset=/%s/%s\n", d, so.Name)
+		case "dnsmasq-nftset":
+			fmt.Fprintf(w, "nftset=/%s/4#%s#%s#%s\n", d, so.NftFamily, so.NftTable, so.Name)
+		}
+		if server != "" {
+			fmt.Fprintf(w, "server=/%s/%s\n", d, server)
+		}
+	}
+	return nil
+}
+
+// Вывод конфигурации unbound для доменов opts.Domains: при заданном Server - секции forward-zone,
+// направляющие запросы доменов на этот сервер, иначе local-zone с типом ZoneType.
+func outputUnbound(w io.Writer, opts OutputOptions) error {
+	if opts.DNS.Server != "" {
+		ip, port, err := opts.DNS.server()
+		if err != nil {
+			return err
+		}
+		addr := ip.String()
+		if port > 0 {
+			addr += "@" + strconv.Itoa(port)
+		}
+		for _, d := range opts.Domains {
+			fmt.Fprintf(w, "forward-zone:\n\tname: \"%s.\"\n\tforward-addr: %s\n", d, addr)
+		}
+		return nil
+	}
+
+	zoneType := opts.DNS.ZoneType
+	if zoneType == "" {
+		zoneType = "always_nxdomain"
+	}
+	if !regexpUnboundZoneType.MatchString(zoneType) {
+		return fmt.Errorf("invalid local-zone type %q", zoneType)
+	}
+	fmt.Fprint(w, "server:\n")
+	for _, d := range opts.Domains {
+		fmt.Fprintf(w, "\tlocal-zone: \"%s.\" %s\n", d, zoneType)
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package routes
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+)
+
+// Допустимое имя устройства, таблицы или протокола iproute2
+var regexpIPRoute2Name = regexp.MustCompile(`^[A-Za-z0-9_.:@-]+$`)
+
+// Параметры формата iproute2
+type IPRoute2Options struct {
+	Dev      string // устройство маршрутов
+	Gateway  string // шлюз маршрутов
+	Table    string // таблица маршрутизации, по умолчанию main
+	Metric   int    // метрика маршрутов, 0 - не указывается
+	Protocol string // протокол маршрутов (proto), по умолчанию не указывается
+	Cleanup  string // удаление прежних маршрутов: flush - очистка таблицы, delete - удаление исчезнувших с прошлого запуска маршрутов и исключений
+}
+
+// Вывод команд для ip -batch. Маршруты заменяются командой route replace, исключения выводятся
+// маршрутами throw: поиск адреса продолжается по следующему правилу маршрутизации.
+func outputIPRoute2(w io.Writer, routes []*Route, opts OutputOptions) error {
+	io2 := opts.IPRoute2
+	var params, suffix string
+	if io2.Gateway != "" {
+		gw := net.ParseIP(io2.Gateway).To4()
+		if gw == nil {
+			return fmt.Errorf("invalid gateway %q", io2.Gateway)
+		}
+		params += " via " + gw.String()
+	}
+	if io2.Dev != "" {
+		params += " dev " + io2.Dev
+	}
+	if params == "" {
+		return errors.New("iproute2 format requires device or gateway")
+	}
+	if io2.Table != "" {
+		suffix += " table " + io2.Table
+	}
+	if io2.Metric > 0 {
+		suffix += fmt.Sprintf(" metric %d", io2.Metric)
+	}
+	for _, name := range []string{io2.Dev, io2.Table, io2.Protocol} {
+		if name != "" && !regexpIPRoute2Name.MatchString(name) {
+			return fmt.Errorf("invalid iproute2 parameter %q", name)
+		}
+	}
+	proto := ""
+	if io2.Protocol != "" {
+		proto = " proto " + io2.Protocol
+	}
+
+	switch io2.Cleanup {
+	case "":
+	case "flush":
+		// очистка основной таблицы удалит и маршруты, не относящиеся к блоклисту
+		if io2.Table == "" || io2.Table == "main" || io2.Table == "254" {
+			return errors.New("iproute2 flush requires a separate routing table")
+		}
+		fmt.Fprintf(w, "route flush table %s%s\n", io2.Table, proto)
+	case "delete":
+		if opts.Previous == nil {
+			return errors.New("iproute2 delete requires previous routes")
+		}
+		_, removed := opts.Previous.Diff(routes)
+		for _, n := range removed {
+			fmt.Fprintf(w, "route delete %s%s\n", n, suffix)
+		}
+		// маршрут throw, оставшийся от прежнего исключения, продолжал бы направлять адреса мимо туннеля
+		for _, e := range opts.Previous.RemovedExceptions(routes) {
+			fmt.Fprintf(w, "route delete throw %s%s\n", e, suffix)
+		}
+	default:
+		return fmt.Errorf("unknown iproute2 cleanup mode %q", io2.Cleanup)
+	}
+
+	for _, r := range routes {
+		fmt.Fprintf(w, "route replace %s%s%s%s\n", r.Network(), params, suffix, proto)
+		for _, e := range r.Exceptions {
+			fmt.Fprintf(w, "route replace throw %s%s%s\n", e, suffix, proto)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package routes
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// Параметры формата pac
+type PACOptions struct {
+	Proxy string // значение, возвращаемое для заблокированных адресов, например "HTTPS proxy.example.com:443"
+}
+
+// Функция FindProxyForURL: домен проверяется по суффиксам в хэш-таблице доменов,
+// адрес - двоичным поиском по упорядоченным диапазонам [начало, конец, ...].
+const pacFunction = `function ipToInt(ip) {
+	var p = ip.split(".");
+	return p[0] * 16777216 + p[1] * 65536 + p[2] * 256 + p[3] * 1;
+}
+
+function inRanges(n) {
+	var lo = 0, hi = ranges.length / 2 - 1;
+	while (lo <= hi) {
+		var mid = (lo + hi) >> 1;
+		if (n < ranges[mid * 2]) {
+			hi = mid - 1;
+		} else if (n > ranges[mid * 2 + 1]) {
+			lo = mid + 1;
+		} else {
+			return true;
+		}
+	}
+	return false;
+}
+
+function FindProxyForURL(url, host) {
+	host = host.toLowerCase();
+	for (var d = host; ; d = d.substring(d.indexOf(".") + 1)) {
+		if (domains.hasOwnProperty(d)) {
+			return proxy;
+		}
+		if (d.indexOf(".") < 0) {
+			break;
+		}
+	}
+	if (ranges.length > 0) {
+		var ip = /^\d+\.\d+\.\d+\.\d+$/.test(host) ? host : dnsResolve(host);
+		if (ip && inRanges(ipToInt(ip))) {
+			return proxy;
+		}
+	}
+	return "DIRECT";
+}
+`
+
+// Вывод файла автоматической настройки прокси (PAC). Заблокированные домены берутся из opts.Domains,
+// маршруты объединяются в непрерывные диапазоны адресов без исключений.
+func outputPAC(w io.Writer, routes []*Route, opts OutputOptions) error {
+	if opts.PAC.Proxy == "" {
+		return errors.New("pac format requires proxy")
+	}
+	proxy, err := json.Marshal(opts.PAC.Proxy)
+	if err != nil {
+		return err
+	}
+
+	var ranges [][2]uint32
+	for _, r := range routes {
+		ranges = append(ranges, routeRanges(r)...)
+	}
+	sort.Slice(ranges, func(i, j int) bool {
+		return ranges[i][0] < ranges[j][0]
+	})
+	// соседние диапазоны объединяются
+	merged := ranges[:0]
+	for _, rng := range ranges {
+		if n := len(merged); n > 0 && uint64(merged[n-1][1])+1 >= uint64(rng[0]) {
+			if rng[1] > merged[n-1][1] {
+				merged[n-1][1] = rng[1]
+			}
+			continue
+		}
+		merged = append(merged, rng)
+	}
+
+	fmt.Fprintf(w, "var proxy = %s;\n", proxy)
+	fmt.Fprint(w, "var domains = {")
+	for i, d := range opts.Domains {
+		if i > 0 {
+			fmt.Fprint(w, ",")
+		}
+		if i%16 == 0 {
+			fmt.Fprint(w, "\n\t")
+		}
+		name, err := json.Marshal(d)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "%s:1", name)
+	}
+	fmt.Fprint(w, "\n};\n")
+	fmt.Fprint(w, "var ranges = [")
+	for i, rng := range merged {
+		if i > 0 {
+			fmt.Fprint(w, ",")
+		}
+		if i%8 == 0 {
+			fmt.Fprint(w, "\n\t")
+		}
+		fmt.Fprintf(w, "%d,%d", rng[0], rng[1])
+	}
+	fmt.Fprint(w, "\n];\n\n")
+	_, err = fmt.Fprint(w, pacFunction)
+	return err
+}
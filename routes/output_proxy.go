@@ -0,0 +1,114 @@
+package routes
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// Параметры форматов прокси-клиентов sing-box, xray и clash
+type ProxyOptions struct {
+	XrayOutbound string // outboundTag правил xray, по умолчанию proxy
+}
+
+// Правило source rule-set sing-box
+type singBoxRule struct {
+	DomainSuffix []string `json:"domain_suffix,omitempty"`
+	IPCIDR       []string `json:"ip_cidr,omitempty"`
+}
+
+// Правило маршрутизации xray
+type xrayRule struct {
+	Type        string   `json:"type"`
+	Domain      []string `json:"domain,omitempty"`
+	IP          []string `json:"ip,omitempty"`
+	OutboundTag string   `json:"outboundTag"`
+}
+
+// Вывод маршрутов и доменов opts.Domains для прокси-клиентов: source rule-set sing-box,
+// правила маршрутизации xray или rule-provider clash. Маршруты с исключениями разбиваются на подсети без исключений.
+func outputProxy(w io.Writer, routes []*Route, opts OutputOptions) error {
+	var cidrs []string
+	for _, r := range routes {
+		cidrs = append(cidrs, routeCIDRs(r)...)
+	}
+
+	switch opts.Format {
+	case "sing-box":
+		ruleSet := struct {
+			Version int           `json:"version"`
+			Rules   []singBoxRule `json:"rules"`
+		}{
+			Version: 1,
+			Rules:   []singBoxRule{},
+		}
+		if len(opts.Domains) > 0 || len(cidrs) > 0 {
+			// правило срабатывает при совпадении домена или адреса
+			ruleSet.Rules = append(ruleSet.Rules, singBoxRule{DomainSuffix: opts.Domains, IPCIDR: cidrs})
+		}
+		return writeJSON(w, ruleSet)
+	case "xray":
+		outbound := opts.Proxy.XrayOutbound
+		if outbound == "" {
+			outbound = "proxy"
+		}
+		// условия одного правила xray должны выполняться одновременно, поэтому домены и адреса в разных правилах
+		rules := []xrayRule{}
+		if len(opts.Domains) > 0 {
+			domains := make([]string, 0, len(opts.Domains))
+			for _, d := range opts.Domains {
+				domains = append(domains, "domain:"+d)
+			}
+			rules = append(rules, xrayRule{Type: "field", Domain: domains, OutboundTag: outbound})
+		}
+		if len(cidrs) > 0 {
+			rules = append(rules, xrayRule{Type: "field", IP: cidrs, OutboundTag: outbound})
+		}
+		var config struct {
+			Routing struct {
+				Rules []xrayRule `json:"rules"`
+			} `json:"routing"`
+		}
+		config.Routing.Rules = rules
+		return writeJSON(w, config)
+	case "clash":
+		// rule-provider с behavior: classical допускает в одном списке домены и подсети
+		if len(opts.Domains) == 0 && len(cidrs) == 0 {
+			_, err := fmt.Fprint(w, "payload: []\n")
+			return err
+		}
+		fmt.Fprint(w, "payload:\n")
+		for _, d := range opts.Domains {
+			fmt.Fprintf(w, "  - DOMAIN-SUFFIX,%s\n", d)
+		}
+		// no-resolve: правило по адресу не должно вызывать разрешение имени, совпадения доменов проверяются отдельно
+		for _, c := range cidrs {
+			fmt.Fprintf(w, "  - IP-CIDR,%s,no-resolve\n", c)
+		}
+	}
+	return nil
+}
+
+func writeJSON(w io.Writer, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", data)
+	return err
+}
+
+// Подсети маршрута в CIDR: подсеть маршрута либо минимальный набор подсетей без исключений
+func routeCIDRs(r *Route) []string {
+	if len(r.Exceptions) == 0 {
+		return []string{r.Network().String()}
+	}
+	var cidrs []string
+	for _, rng := range routeRanges(r) {
+		sc := ipScanner{rangeLo: rng[0], rangeHi: rng[1], inRange: true}
+		for p, ok := sc.Next(); ok; p, ok = sc.Next() {
+			cidrs = append(cidrs, p.String())
+		}
+	}
+	return cidrs
+}
@@ -0,0 +1,138 @@
+package routes
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+)
+
+// Параметры форматов routeros-address-list и routeros-route
+type RouterOSOptions struct {
+	List         string // имя address-list, по умолчанию blocked
+	Gateway      string // шлюз маршрутов, обязателен для routeros-route
+	RoutingTable string // таблица маршрутизации (RouterOS 7), по умолчанию main
+	Comment      string // комментарий, по которому находятся добавленные утилитой записи, по умолчанию blocked_routes
+	Diff         bool   // добавлять и удалять только изменившиеся относительно Previous записи
+}
+
+// Вывод скрипта RouterOS. Полный скрипт удаляет записи с комментарием Comment, которых нет среди маршрутов,
+// и добавляет недостающие: набор маршрутов задается массивом, с которым сравниваются имеющиеся записи.
+// В режиме Diff удаляются только исчезнувшие и добавляются только новые относительно предыдущего запуска записи:
+// импорт десятков тысяч записей в RouterOS занимает много времени. Неизменные записи перечисляются
+// в комментариях, чтобы скрипт можно было использовать как -previous следующего запуска.
+func outputRouterOS(w io.Writer, routes []*Route, opts OutputOptions) error {
+	ro := opts.RouterOS
+	list := ro.List
+	if list == "" {
+		list = "blocked"
+	}
+	comment := ro.Comment
+	if comment == "" {
+		comment = "blocked_routes"
+	}
+	for _, r := range routes {
+		if len(r.Exceptions) > 0 {
+			return errors.New("RouterOS formats don't support route exceptions")
+		}
+	}
+
+	var gw net.IP
+	table, tableFilter := "", ""
+	if opts.Format == "routeros-route" {
+		if gw = net.ParseIP(ro.Gateway).To4(); gw == nil {
+			return errors.New("routeros-route format requires IPv4 gateway")
+		}
+		if ro.RoutingTable != "" {
+			table = " routing-table=" + routerOSValue(ro.RoutingTable)
+			tableFilter = fmt.Sprintf(" && [get $i routing-table] = %s", routerOSString(ro.RoutingTable))
+		}
+	}
+
+	switch opts.Format {
+	case "routeros-address-list":
+		fmt.Fprint(w, "/ip firewall address-list\n")
+	case "routeros-route":
+		fmt.Fprint(w, "/ip route\n")
+	}
+
+	if !ro.Diff {
+		// значение элемента массива: "add" - запись нужно добавить, "keep" - запись уже есть
+		fmt.Fprint(w, "{\n:local keep [:toarray \"\"]\n")
+		for _, r := range routes {
+			fmt.Fprintf(w, ":set ($keep->\"%s\") \"add\"\n", r.Network())
+		}
+		switch opts.Format {
+		case "routeros-address-list":
+			fmt.Fprintf(w, ":foreach i in=[find list=%s comment=%s] do={\n", routerOSValue(list), routerOSValue(comment))
+			// адрес /32 показывается без маски
+			fmt.Fprint(w, ":local a [get $i address]\n:if ([:typeof [:find $a \"/\"]] = \"nil\") do={:set a \"$a/32\"}\n")
+			fmt.Fprint(w, ":if (($keep->$a) = \"add\") do={:set ($keep->$a) \"keep\"} else={remove $i}\n}\n")
+			fmt.Fprintf(w, ":foreach a,v in=$keep do={:if ($v = \"add\") do={add list=%s address=$a comment=%s}}\n",
+				routerOSValue(list), routerOSValue(comment))
+		case "routeros-route":
+			fmt.Fprintf(w, ":foreach i in=[find comment=%s] do={\n:local a [get $i dst-address]\n", routerOSValue(comment))
+			fmt.Fprintf(w, ":if (($keep->$a) = \"add\" && [get $i gateway] = \"%s\"%s) do={:set ($keep->$a) \"keep\"} else={remove $i}\n}\n",
+				gw, tableFilter)
+			fmt.Fprintf(w, ":foreach a,v in=$keep do={:if ($v = \"add\") do={add dst-address=$a gateway=%s%s comment=%s}}\n",
+				gw, table, routerOSValue(comment))
+		}
+		fmt.Fprint(w, "}\n")
+		return nil
+	}
+
+	if opts.Previous == nil {
+		return errors.New("RouterOS diff mode requires previous routes")
+	}
+	added, removed := opts.Previous.Diff(routes)
+	isAdded := make(map[string]struct{}, len(added))
+	for _, n := range added {
+		isAdded[n.String()] = struct{}{}
+	}
+	for _, r := range routes {
+		n := r.Network()
+		if _, ok := isAdded[n.String()]; !ok {
+			fmt.Fprintf(w, "# keep %s\n", n)
+		}
+	}
+	switch opts.Format {
+	case "routeros-address-list":
+		for _, n := range removed {
+			fmt.Fprintf(w, "remove [find list=%s address=%s comment=%s]\n", routerOSValue(list), routerOSAddress(n), routerOSValue(comment))
+		}
+		for _, n := range added {
+			fmt.Fprintf(w, "add list=%s address=%s comment=%s\n", routerOSValue(list), routerOSAddress(n), routerOSValue(comment))
+		}
+	case "routeros-route":
+		for _, n := range removed {
+			fmt.Fprintf(w, "remove [find dst-address=%s%s comment=%s]\n", n, table, routerOSValue(comment))
+		}
+		for _, n := range added {
+			fmt.Fprintf(w, "add dst-address=%s gateway=%s%s comment=%s\n", n, gw, table, routerOSValue(comment))
+		}
+	}
+	return nil
+}
+
+// RouterOS показывает адрес /32 в address-list без маски, поэтому поиск по нему должен быть без маски
+func routerOSAddress(n *net.IPNet) string {
+	if ones, _ := n.Mask.Size(); ones == 32 {
+		return n.IP.String()
+	}
+	return n.String()
+}
+
+// Значение параметра RouterOS, в кавычках при наличии пробелов и спецсимволов
+func routerOSValue(s string) string {
+	if s != "" && !strings.ContainsAny(s, " \t\"\\;[]{}$=") {
+		return s
+	}
+	return routerOSString(s)
+}
+
+// Строка RouterOS в кавычках
+func routerOSString(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, `$`, `\$`)
+	return `"` + r.Replace(s) + `"`
+}
@@ -0,0 +1,151 @@
+package routes
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"sort"
+)
+
+// Допустимое имя набора ipset и таблицы или набора nftables
+var regexpSetName = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// Кол-во элементов в одной команде add element nftables
+const nftElementsPerCommand = 1000
+
+// Параметры форматов ipset и nft
+type SetOptions struct {
+	Name      string // имя набора, по умолчанию blocked
+	NftTable  string // таблица nftables, по умолчанию blocked_routes
+	NftFamily string // семейство таблицы nftables, по умолчанию inet
+}
+
+func (so SetOptions) withDefaults() SetOptions {
+	if so.Name == "" {
+		so.Name = "blocked"
+	}
+	if so.NftTable == "" {
+		so.NftTable = "blocked_routes"
+	}
+	if so.NftFamily == "" {
+		so.NftFamily = "inet"
+	}
+	return so
+}
+
+// Вывод файла для ipset restore. Маршруты добавляются во временный набор hash:net, который затем
+// атомарно подменяет основной. Исключения добавляются с флагом nomatch.
+func outputIPSet(w io.Writer, routes []*Route, opts OutputOptions) error {
+	so := opts.Set.withDefaults()
+	if !regexpSetName.MatchString(so.Name) {
+		return fmt.Errorf("invalid set name %q", so.Name)
+	}
+	tmp := so.Name + "-tmp"
+
+	count := 0
+	for _, r := range routes {
+		count += 1 + len(r.Exceptions)
+	}
+	// create -exist завершается ошибкой, если параметры существующего набора отличаются,
+	// поэтому maxelem не зависит от кол-ва маршрутов, пока оно не превысит миллион
+	maxElem := 1 << 20
+	for maxElem < count {
+		maxElem *= 2
+	}
+
+	fmt.Fprintf(w, "create %s hash:net family inet maxelem %d -exist\n", so.Name, maxElem)
+	fmt.Fprintf(w, "create %s hash:net family inet maxelem %d -exist\n", tmp, maxElem)
+	fmt.Fprintf(w, "flush %s\n", tmp)
+	for _, r := range routes {
+		fmt.Fprintf(w, "add %s %s\n", tmp, r.Network())
+		for _, e := range r.Exceptions {
+			fmt.Fprintf(w, "add %s %s nomatch\n", tmp, e)
+		}
+	}
+	fmt.Fprintf(w, "swap %s %s\n", tmp, so.Name)
+	_, err := fmt.Fprintf(w, "destroy %s\n", tmp)
+	return err
+}
+
+// Вывод скрипта nft -f. Набор очищается и заполняется заново в одной транзакции.
+// Маршрут с исключениями выводится диапазонами адресов без исключенных подсетей.
+func outputNft(w io.Writer, routes []*Route, opts OutputOptions) error {
+	so := opts.Set.withDefaults()
+	for _, name := range []string{so.Name, so.NftTable, so.NftFamily} {
+		if !regexpSetName.MatchString(name) {
+			return fmt.Errorf("invalid nftables name %q", name)
+		}
+	}
+	set := fmt.Sprintf("%s %s %s", so.NftFamily, so.NftTable, so.Name)
+
+	// объявление таблицы и набора не изменяет их, если они уже существуют
+	fmt.Fprintf(w, "table %s %s {\n\tset %s {\n\t\ttype ipv4_addr\n\t\tflags interval\n\t}\n}\n", so.NftFamily, so.NftTable, so.Name)
+	fmt.Fprintf(w, "flush set %s\n", set)
+
+	var elements []string
+	for _, r := range routes {
+		elements = append(elements, nftElements(r)...)
+	}
+	for len(elements) > 0 {
+		n := len(elements)
+		if n > nftElementsPerCommand {
+			n = nftElementsPerCommand
+		}
+		fmt.Fprintf(w, "add element %s { ", set)
+		for i, e := range elements[:n] {
+			if i > 0 {
+				fmt.Fprint(w, ", ")
+			}
+			fmt.Fprint(w, e)
+		}
+		fmt.Fprint(w, " }\n")
+		elements = elements[n:]
+	}
+	return nil
+}
+
+// Элементы набора nftables для маршрута: подсеть либо диапазоны между исключениями
+func nftElements(r *Route) []string {
+	if len(r.Exceptions) == 0 {
+		return []string{r.Network().String()}
+	}
+	var elements []string
+	for _, rng := range routeRanges(r) {
+		elements = append(elements, ipv4String(rng[0])+"-"+ipv4String(rng[1]))
+	}
+	return elements
+}
+
+// Диапазоны адресов маршрута без исключенных подсетей: первый и последний адрес каждого диапазона
+func routeRanges(r *Route) [][2]uint32 {
+	exceptions := make([]Prefix, 0, len(r.Exceptions))
+	for _, e := range r.Exceptions {
+		exceptions = append(exceptions, PrefixOf(e))
+	}
+	sort.Slice(exceptions, func(i, j int) bool {
+		return exceptions[i].Addr < exceptions[j].Addr
+	})
+
+	p := PrefixOf(r.Network())
+	start := uint64(p.Addr)
+	end := start + uint64(1)<<(32-uint(p.Len))
+	var ranges [][2]uint32
+	for _, e := range exceptions {
+		if uint64(e.Addr) > start {
+			ranges = append(ranges, [2]uint32{uint32(start), e.Addr - 1})
+		}
+		start = uint64(e.Addr) + uint64(1)<<(32-uint(e.Len))
+	}
+	if start < end {
+		ranges = append(ranges, [2]uint32{uint32(start), uint32(end - 1)})
+	}
+	return ranges
+}
+
+func ipv4String(addr uint32) string {
+	ip := make(net.IP, 4)
+	binary.BigEndian.PutUint32(ip, addr)
+	return ip.String()
+}
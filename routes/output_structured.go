@@ -0,0 +1,104 @@
+package routes
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+)
+
+// Имя утилиты в заголовке форматов json и yaml
+const generatorName = "blocked_routes"
+
+// Содержимое форматов json и yaml: заголовок с параметрами запуска и итогами, затем маршруты.
+// Время запуска не выводится, чтобы файл -out менялся только при изменении маршрутов.
+type structuredOutput struct {
+	Generator string            `json:"generator"`
+	Version   string            `json:"version,omitempty"`
+	Source    string            `json:"source,omitempty"`
+	Updated   string            `json:"updated,omitempty"`
+	Options   map[string]string `json:"options,omitempty"`
+	Totals    ReportTotals      `json:"totals"`
+	Churn     *ReportChurn      `json:"churn,omitempty"`
+	Routes    []ReportRoute     `json:"routes"`
+}
+
+func newStructuredOutput(routes []*Route, opts OutputOptions) *structuredOutput {
+	report := opts.Report
+	if report == nil {
+		report = NewReport(routes, 0)
+	}
+	return &structuredOutput{
+		Generator: generatorName,
+		Version:   opts.Meta.Version,
+		Source:    opts.Meta.Source,
+		Updated:   opts.Meta.Updated,
+		Options:   opts.Meta.Options,
+		Totals:    report.Totals,
+		Churn:     report.Churn,
+		Routes:    report.Routes,
+	}
+}
+
+// Вывод маршрутов в формате JSON
+func outputJSON(w io.Writer, routes []*Route, opts OutputOptions) error {
+	return writeJSON(w, newStructuredOutput(routes, opts))
+}
+
+// Вывод маршрутов в формате YAML. Структура совпадает с форматом json, все строки выводятся в кавычках.
+func outputYAML(w io.Writer, routes []*Route, opts OutputOptions) error {
+	out := newStructuredOutput(routes, opts)
+	fmt.Fprintf(w, "generator: %s\n", yamlString(out.Generator))
+	if out.Version != "" {
+		fmt.Fprintf(w, "version: %s\n", yamlString(out.Version))
+	}
+	if out.Source != "" {
+		fmt.Fprintf(w, "source: %s\n", yamlString(out.Source))
+	}
+	if out.Updated != "" {
+		fmt.Fprintf(w, "updated: %s\n", yamlString(out.Updated))
+	}
+	if len(out.Options) > 0 {
+		names := make([]string, 0, len(out.Options))
+		for name := range out.Options {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		fmt.Fprint(w, "options:\n")
+		for _, name := range names {
+			fmt.Fprintf(w, "  %s: %s\n", yamlString(name), yamlString(out.Options[name]))
+		}
+	}
+
+	t := out.Totals
+	fmt.Fprint(w, "totals:\n")
+	fmt.Fprintf(w, "  routes: %d\n  exceptions: %d\n", t.Routes, t.Exceptions)
+	fmt.Fprintf(w, "  blocked: %d\n  covered: %d\n  collateral: %d\n", t.Blocked, t.Covered, t.Collateral)
+	fmt.Fprintf(w, "  collateral_ratio: %s\n", strconv.FormatFloat(t.CollateralRatio, 'g', -1, 64))
+	fmt.Fprintf(w, "  excluded: %d\n", t.Excluded)
+	if out.Churn != nil {
+		fmt.Fprintf(w, "churn:\n  added: %d\n  removed: %d\n", out.Churn.Added, out.Churn.Removed)
+	}
+
+	if len(out.Routes) == 0 {
+		_, err := fmt.Fprint(w, "routes: []\n")
+		return err
+	}
+	fmt.Fprint(w, "routes:\n")
+	for _, r := range out.Routes {
+		fmt.Fprintf(w, "  - prefix: %s\n", yamlString(r.Prefix))
+		fmt.Fprintf(w, "    blocked: %d\n    collateral: %d\n    penalty: %d\n", r.Blocked, r.Collateral, r.Penalty)
+		if len(r.Exceptions) > 0 {
+			fmt.Fprint(w, "    exceptions:\n")
+			for _, e := range r.Exceptions {
+				fmt.Fprintf(w, "      - %s\n", yamlString(e))
+			}
+		}
+	}
+	return nil
+}
+
+// Строка YAML в двойных кавычках. Escape-последовательности strconv.Quote допустимы в YAML.
+func yamlString(s string) string {
+	return strconv.Quote(s)
+}
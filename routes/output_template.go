@@ -0,0 +1,100 @@
+package routes
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Сведения о запуске для пользовательских шаблонов и форматов json и yaml
+type OutputMeta struct {
+	Source    string            // источник блоклиста
+	Updated   string            // время формирования блоклиста, если известно
+	Generated time.Time         // время формирования маршрутов
+	Version   string            // версия утилиты
+	Options   map[string]string // ключи запуска, влияющие на результат
+}
+
+// Данные пользовательского шаблона
+type TemplateData struct {
+	OutputMeta
+	Routes []TemplateRoute // итоговые маршруты
+}
+
+// Маршрут в данных шаблона
+type TemplateRoute struct {
+	IP         string          // адрес подсети
+	Mask       string          // маска в виде адреса, 255.255.255.0
+	Wildcard   string          // обратная маска, 0.0.0.255
+	PrefixLen  int             // длина префикса
+	CIDR       string          // подсеть в виде CIDR
+	Blocked    int             // кол-во заблокированных адресов
+	Collateral int             // кол-во незаблокированных адресов, попавших в маршрут
+	Exceptions []TemplateRoute // вложенные подсети, направляемые мимо туннеля (без показателей)
+}
+
+// Вспомогательные функции пользовательских шаблонов
+var TemplateFuncs = template.FuncMap{
+	"join":       strings.Join,
+	"upper":      strings.ToUpper,
+	"lower":      strings.ToLower,
+	"replace":    func(s, old, new string) string { return strings.Replace(s, old, new, -1) },
+	"quote":      strconv.Quote,
+	"add":        func(a, b int) int { return a + b },
+	"sub":        func(a, b int) int { return a - b },
+	"formatTime": func(layout string, t time.Time) string { return t.Format(layout) },
+	"ipToInt": func(ip string) uint32 {
+		if ip4 := net.ParseIP(ip).To4(); ip4 != nil {
+			return binary.BigEndian.Uint32(ip4)
+		}
+		return 0
+	},
+}
+
+// Загрузка пользовательского шаблона из файла
+func ParseTemplateFile(path string) (*template.Template, error) {
+	return template.New(filepath.Base(path)).Funcs(TemplateFuncs).ParseFiles(path)
+}
+
+// Данные шаблона для итоговых маршрутов
+func NewTemplateData(routes []*Route, meta OutputMeta) *TemplateData {
+	data := &TemplateData{
+		OutputMeta: meta,
+		Routes:     make([]TemplateRoute, 0, len(routes)),
+	}
+	for _, r := range routes {
+		tr := templateRoute(r.Network())
+		tr.Blocked = int(r.Node.SubtreeSize)
+		tr.Collateral = int(r.Covered() - r.Node.SubtreeSize)
+		for _, e := range r.Exceptions {
+			tr.Exceptions = append(tr.Exceptions, templateRoute(e))
+		}
+		data.Routes = append(data.Routes, tr)
+	}
+	return data
+}
+
+func templateRoute(n *net.IPNet) TemplateRoute {
+	ones, _ := n.Mask.Size()
+	wildcard := make(net.IP, len(n.Mask))
+	for i, b := range n.Mask {
+		wildcard[i] = ^b
+	}
+	return TemplateRoute{
+		IP:        n.IP.String(),
+		Mask:      net.IP(n.Mask).String(),
+		Wildcard:  wildcard.String(),
+		PrefixLen: ones,
+		CIDR:      n.String(),
+	}
+}
+
+// Вывод маршрутов по пользовательскому шаблону
+func outputTemplate(w io.Writer, routes []*Route, opts OutputOptions) error {
+	return opts.Template.Execute(w, NewTemplateData(routes, opts.Meta))
+}
@@ -0,0 +1,19 @@
+package routes
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestOutputNetsUnknownFormat(t *testing.T) {
+	// ошибка не зависит от наличия маршрутов
+	for _, routes := range [][]*Route{nil, testRoutes(t, "1.2.3.0/24")} {
+		var buf bytes.Buffer
+		if err := OutputNets(&buf, routes, OutputOptions{Format: "typo"}); err == nil {
+			t.Errorf("%d routes: no error for unknown format", len(routes))
+		}
+		if buf.Len() > 0 {
+			t.Errorf("%d routes: unexpected output %q", len(routes), buf.String())
+		}
+	}
+}
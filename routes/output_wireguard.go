@@ -0,0 +1,86 @@
+package routes
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"text/template"
+)
+
+// Секция [Peer] для wg-quick
+var wgQuickPeerTemplate = template.Must(template.New("wg-quick").Parse(`[Peer]
+PublicKey = {{.PublicKey}}
+{{if .Endpoint}}Endpoint = {{.Endpoint}}
+{{end}}{{range .AllowedIPs}}AllowedIPs = {{.}}
+{{end}}`))
+
+// Параметры форматов wireguard и wg-quick
+type WireGuardOptions struct {
+	PublicKey     string   // публичный ключ пира, обязателен для wg-quick
+	Endpoint      string   // адрес пира host:port, необязателен
+	Extra         []string // дополнительные подсети в CIDR, в т.ч. IPv6, добавляемые после маршрутов
+	MaxLineLength int      // максимальная длина строки AllowedIPs, 0 - без ограничения
+}
+
+// Вывод маршрутов в строках "AllowedIPs = ...". Если строка превышает MaxLineLength, подсети переносятся
+// в следующую строку AllowedIPs: wg-quick объединяет все такие строки одного пира.
+func outputWireGuard(w io.Writer, routes []*Route, opts OutputOptions) error {
+	lines, err := wireGuardAllowedIPs(routes, opts.WireGuard)
+	if err != nil {
+		return err
+	}
+
+	if opts.Format == "wg-quick" {
+		if opts.WireGuard.PublicKey == "" {
+			return errors.New("wg-quick format requires peer public key")
+		}
+		return wgQuickPeerTemplate.Execute(w, struct {
+			WireGuardOptions
+			AllowedIPs []string
+		}{opts.WireGuard, lines})
+	}
+
+	for _, line := range lines {
+		if _, err := fmt.Fprintf(w, "AllowedIPs = %s\n", line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Разбиение подсетей на значения AllowedIPs с учетом ограничения длины строки
+func wireGuardAllowedIPs(routes []*Route, opts WireGuardOptions) (lines []string, err error) {
+	nets := make([]string, 0, len(routes)+len(opts.Extra))
+	for _, r := range routes {
+		if len(r.Exceptions) > 0 {
+			return nil, errors.New("WireGuard doesn't support route exceptions")
+		}
+		nets = append(nets, r.Network().String())
+	}
+	for _, e := range opts.Extra {
+		_, n, err := net.ParseCIDR(strings.TrimSpace(e))
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n.String())
+	}
+
+	const prefix = len("AllowedIPs = ")
+	var line strings.Builder
+	for _, n := range nets {
+		if line.Len() > 0 && opts.MaxLineLength > 0 && prefix+line.Len()+len(", ")+len(n) > opts.MaxLineLength {
+			lines = append(lines, line.String())
+			line.Reset()
+		}
+		if line.Len() > 0 {
+			line.WriteString(", ")
+		}
+		line.WriteString(n)
+	}
+	if line.Len() > 0 {
+		lines = append(lines, line.String())
+	}
+	return lines, nil
+}
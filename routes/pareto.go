@@ -0,0 +1,76 @@
+package routes
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"net"
+	"strconv"
+)
+
+// Точка кривой Парето: кол-во маршрутов и незаблокированных адресов в них
+type ParetoPoint struct {
+	Routes     uint   `json:"routes"`
+	Collateral uint64 `json:"collateral"`
+}
+
+// Построение кривой зависимости кол-ва лишних адресов от кол-ва маршрутов за один проход по дереву.
+// Жадное разбиение монотонно, поэтому результат GetOptimizedNets с ограничением max совпадает с первой точкой кривой,
+// где маршрутов не меньше max. Точки отсортированы по возрастанию кол-ва маршрутов.
+func ParetoCurve(tree OptimizeTree, excludeNets []*net.IPNet) (curve []ParetoPoint) {
+	add := func(l *IPTreeNodesList) {
+		if len(curve) > 0 && curve[len(curve)-1].Routes >= l.Size() {
+			return
+		}
+		curve = append(curve, ParetoPoint{Routes: l.Size(), Collateral: l.Collateral()})
+	}
+
+	l := expandNodes(tree, excludeNets, nil, func(l *IPTreeNodesList) bool {
+		add(l)
+		return false
+	})
+	add(l)
+	return
+}
+
+// Выбор точки перегиба кривой: после неё каждый новый маршрут убирает заметно меньше лишних адресов.
+// Кривая нормируется в единичный квадрат и выбирается точка, наиболее удаленная от прямой между крайними точками.
+func ParetoKnee(curve []ParetoPoint) (knee ParetoPoint) {
+	if len(curve) == 0 {
+		return
+	}
+	first, last := curve[0], curve[len(curve)-1]
+	knee = last
+	if first.Routes == last.Routes || first.Collateral == last.Collateral {
+		return first
+	}
+
+	best := 0.0
+	for _, p := range curve {
+		x := float64(p.Routes-first.Routes) / float64(last.Routes-first.Routes)
+		y := (float64(p.Collateral) - float64(last.Collateral)) / (float64(first.Collateral) - float64(last.Collateral))
+		if d := 1 - x - y; d > best {
+			best = d
+			knee = p
+		}
+	}
+	return
+}
+
+// Вывод кривой в формате CSV
+func WriteParetoCSV(w io.Writer, curve []ParetoPoint) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"routes", "collateral"})
+	for _, p := range curve {
+		cw.Write([]string{strconv.FormatUint(uint64(p.Routes), 10), strconv.FormatUint(p.Collateral, 10)})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// Вывод кривой в формате JSON
+func WriteParetoJSON(w io.Writer, curve []ParetoPoint) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(curve)
+}
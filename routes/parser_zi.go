@@ -0,0 +1,296 @@
+package routes
+
+import (
+	"net"
+	"github.com/amkulikov/ipv4range"
+	"io"
+	"bufio"
+	"strings"
+	"os"
+	"runtime"
+	"sync"
+	"unicode/utf8"
+)
+
+// Парсер для блоклиста от https://github.com/zapret-info/z-i
+type ZapretInfoParser struct {
+	AllowedDomains   []string // Разрешенные домены для выборки в blocklist
+	AllowEmptyDomain bool     // Использование правил с пустыми доменами
+	AllDomains       bool     // Использование любых доменов (в т.ч. пустых)
+	KeepRecords      bool     // Сохранение исходных записей в индексе (см. Records)
+	KeepDomains      bool     // Сохранение доменов выбранных правил (см. Domains)
+	Workers          int      // Кол-во потоков разбора, по умолчанию GOMAXPROCS
+
+	records *RecordIndex
+	updated string
+	domains []string
+}
+
+// Домены правил, выбранных последним разбором, как есть. nil, если домены не сохранялись.
+func (zi *ZapretInfoParser) Domains() []string {
+	return zi.domains
+}
+
+// Время формирования блоклиста из строки "Updated: ..." последнего разбора, либо пустая строка
+func (zi *ZapretInfoParser) Updated() string {
+	return zi.updated
+}
+
+// Индекс исходных записей, заполненный последним разбором. nil, если записи не сохранялись.
+func (zi *ZapretInfoParser) Records() *RecordIndex {
+	return zi.records
+}
+
+// Загрузка списка разрешенных доменов из файла
+func (zi *ZapretInfoParser) LoadAllowedDomains(src string) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	br := bufio.NewReader(f)
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			if err == io.EOF {
+				break
+			} else {
+				return err
+			}
+		}
+
+		zi.AllowedDomains = append(zi.AllowedDomains, strings.TrimSpace(line))
+	}
+	return nil
+}
+
+// Кол-во строк в порции, разбираемой одним потоком
+const ziChunkLines = 4096
+
+// Порция строк блоклиста и результат её разбора
+type ziChunk struct {
+	seq       int      // порядковый номер порции
+	firstLine int      // номер первой строки порции
+	lines     []string // строки порции
+
+	prefixes    []Prefix  // адреса и подсети порции
+	recPrefixes []Prefix  // префиксы для индекса записей
+	recs        []*Record // записи, соответствующие recPrefixes
+	domains     []string  // домены правил порции
+}
+
+// Разбор содержимого блоклиста. Строки читаются порциями, которые разбираются в Workers потоках,
+// а результаты объединяются в исходном порядке строк, поэтому не зависят от числа потоков.
+func (zi *ZapretInfoParser) Parse(r io.Reader) (ips map[ipv4range.IPv4]struct{}, nets []*net.IPNet, e error) {
+	ips = make(map[ipv4range.IPv4]struct{})
+	e = zi.parse(r, func(prefixes []Prefix) {
+		for _, p := range prefixes {
+			if p.Len == 32 {
+				ips[ipv4range.IPv4(p.Addr)] = struct{}{}
+			} else {
+				nets = append(nets, p.IPNet())
+			}
+		}
+	})
+	return
+}
+
+// Разбор содержимого блоклиста в префиксы в порядке строк, без множества адресов и отдельных net.IPNet
+func (zi *ZapretInfoParser) ParsePrefixes(r io.Reader) (prefixes []Prefix, err error) {
+	err = zi.parse(r, func(p []Prefix) {
+		prefixes = append(prefixes, p...)
+	})
+	return
+}
+
+// Разбор блоклиста с передачей префиксов каждой порции в add в порядке строк
+func (zi *ZapretInfoParser) parse(r io.Reader, add func(prefixes []Prefix)) (e error) {
+	zi.records = nil
+	zi.updated = ""
+	zi.domains = nil
+	if zi.KeepDomains {
+		zi.domains = []string{}
+	}
+	if zi.KeepRecords {
+		zi.records = NewRecordIndex()
+	}
+
+	workers := zi.Workers
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+
+	chunks := make(chan *ziChunk, workers)
+	parsed := make(chan *ziChunk, workers)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for c := range chunks {
+				for i, line := range c.lines {
+					zi.parseLine(c.firstLine+i, line, c)
+				}
+				c.lines = nil
+				parsed <- c
+			}
+		}()
+	}
+
+	// Чтение строк порциями
+	go func() {
+		defer close(chunks)
+		lineNum := 0
+		c := &ziChunk{firstLine: 1}
+		br := bufio.NewReader(r)
+		for {
+			line, err := br.ReadString('\n')
+			lineNum++
+			if err != nil {
+				if err != io.EOF {
+					e = err
+				}
+				break
+			}
+			if lineNum == 1 && strings.HasPrefix(line, "Updated:") {
+				zi.updated = strings.TrimSpace(line[len("Updated:"):])
+			}
+			c.lines = append(c.lines, line)
+			if len(c.lines) == ziChunkLines {
+				chunks <- c
+				c = &ziChunk{seq: c.seq + 1, firstLine: lineNum + 1}
+			}
+		}
+		chunks <- c
+	}()
+
+	go func() {
+		wg.Wait()
+		close(parsed)
+	}()
+
+	// Объединение результатов в порядке порций
+	pending := make(map[int]*ziChunk)
+	next := 0
+	for c := range parsed {
+		pending[c.seq] = c
+		for c, ok := pending[next]; ok; c, ok = pending[next] {
+			delete(pending, next)
+			next++
+			add(c.prefixes)
+			zi.domains = append(zi.domains, c.domains...)
+			for i, p := range c.recPrefixes {
+				zi.records.Add(p, c.recs[i])
+			}
+		}
+	}
+	return
+}
+
+// Разбор строки блоклиста с добавлением результата в порцию c
+func (zi *ZapretInfoParser) parseLine(lineNum int, line string, c *ziChunk) {
+	ipPart := line[:]
+	if sep := strings.Index(ipPart, ";"); sep >= 0 {
+		ipPart = ipPart[:sep]
+	} else {
+		return
+	}
+
+	domainPart := ""
+	if !zi.AllDomains || zi.KeepDomains {
+		domainPart = line[len(ipPart)+1:]
+		if sep := strings.Index(domainPart, ";"); sep >= 0 {
+			domainPart = strings.TrimSpace(domainPart[:sep])
+		} else if !zi.AllDomains {
+			return
+		} else {
+			domainPart = ""
+		}
+	}
+
+	if !zi.AllDomains {
+		if len(domainPart) == 0 {
+			if !zi.AllowEmptyDomain {
+				return
+			}
+		} else if len(zi.AllowedDomains) > 0 {
+			skipLine := true
+			for _, d := range zi.AllowedDomains {
+				if strings.HasSuffix(domainPart, d) {
+					skipLine = false
+					break
+				}
+			}
+			if skipLine {
+				return
+			}
+		}
+	}
+
+	if zi.KeepDomains && domainPart != "" {
+		c.domains = append(c.domains, decodeCP1251(domainPart))
+	}
+
+	var rec *Record
+	if zi.KeepRecords {
+		rec = newZapretInfoRecord(lineNum, line)
+	}
+
+	sc := newIPScanner(ipPart)
+	for p, ok := sc.Next(); ok; p, ok = sc.Next() {
+		c.prefixes = append(c.prefixes, p)
+		if rec != nil {
+			c.recPrefixes = append(c.recPrefixes, p)
+			c.recs = append(c.recs, rec)
+		}
+	}
+}
+
+// Разбор строки блоклиста в запись. Формат строки: адреса;домен;URL;орган;номер решения;дата
+func newZapretInfoRecord(lineNum int, line string) *Record {
+	fields := strings.SplitN(strings.TrimRight(decodeCP1251(line), "\r\n"), ";", 6)
+	for len(fields) < 6 {
+		fields = append(fields, "")
+	}
+	return &Record{
+		Line:     lineNum,
+		IPs:      strings.TrimSpace(fields[0]),
+		Domain:   strings.TrimSpace(fields[1]),
+		URL:      strings.TrimSpace(fields[2]),
+		Org:      strings.TrimSpace(fields[3]),
+		Decision: strings.TrimSpace(fields[4]),
+		Date:     strings.TrimSpace(fields[5]),
+	}
+}
+
+// Символы кодировки windows-1251 в диапазоне 0x80-0xBF, с 0xC0 по 0xFF идут А-я подряд
+var cp1251Table = [64]rune{
+	0x0402, 0x0403, 0x201A, 0x0453, 0x201E, 0x2026, 0x2020, 0x2021,
+	0x20AC, 0x2030, 0x0409, 0x2039, 0x040A, 0x040C, 0x040B, 0x040F,
+	0x0452, 0x2018, 0x2019, 0x201C, 0x201D, 0x2022, 0x2013, 0x2014,
+	0xFFFD, 0x2122, 0x0459, 0x203A, 0x045A, 0x045C, 0x045B, 0x045F,
+	0x00A0, 0x040E, 0x045E, 0x0408, 0x00A4, 0x0490, 0x00A6, 0x00A7,
+	0x0401, 0x00A9, 0x0404, 0x00AB, 0x00AC, 0x00AD, 0x00AE, 0x0407,
+	0x00B0, 0x00B1, 0x0406, 0x0456, 0x0491, 0x00B5, 0x00B6, 0x00B7,
+	0x0451, 0x2116, 0x0454, 0x00BB, 0x0458, 0x0405, 0x0455, 0x0457,
+}
+
+// Блоклист z-i распространяется в кодировке windows-1251. Строки в UTF-8 возвращаются как есть.
+func decodeCP1251(s string) string {
+	if utf8.ValidString(s) {
+		return s
+	}
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c < 0x80:
+			sb.WriteByte(c)
+		case c < 0xC0:
+			sb.WriteRune(cp1251Table[c-0x80])
+		default:
+			sb.WriteRune(0x0410 + rune(c-0xC0))
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,78 @@
+package routes
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// Результат разбора, не зависящий от адресов в памяти
+type ziResult struct {
+	ips     []uint32
+	nets    []string
+	domains []string
+	updated string
+	records map[Prefix][]*Record
+}
+
+func parseZI(t *testing.T, zi *ZapretInfoParser, r io.Reader) ziResult {
+	ips, nets, err := zi.Parse(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := ziResult{domains: zi.Domains(), updated: zi.Updated(), records: zi.Records().records}
+	for ip := range ips {
+		res.ips = append(res.ips, uint32(ip))
+	}
+	sort.Slice(res.ips, func(i, j int) bool { return res.ips[i] < res.ips[j] })
+	for _, n := range nets {
+		res.nets = append(res.nets, n.String())
+	}
+	return res
+}
+
+// Дамп из нескольких порций. Последняя строка первой порции длиннее буфера чтения.
+func chunkedDump() (dump string, boundary int) {
+	lines := strings.SplitAfter(testDump(3*ziChunkLines+100), "\n")
+	var long []string
+	for i := 0; i < 400; i++ {
+		long = append(long, fmt.Sprintf("77.%d.%d.%d", i/256, i%256, i%7))
+	}
+	long = append(long, "78.0.0.0/16")
+	lines[ziChunkLines-1] = strings.Join(long, " | ") + ";long.example.com;;;;\n"
+
+	boundary = len(strings.Join(lines[:ziChunkLines-1], ""))
+	return strings.Join(lines, ""), boundary
+}
+
+// Результат разбора не зависит от числа потоков и от того, как данные приходят из io.Reader
+func TestZapretInfoParserWorkers(t *testing.T) {
+	dump, boundary := chunkedDump()
+	newParser := func(workers int) *ZapretInfoParser {
+		return &ZapretInfoParser{AllowEmptyDomain: true, KeepRecords: true, KeepDomains: true, Workers: workers}
+	}
+	want := parseZI(t, newParser(1), strings.NewReader(dump))
+
+	// строки длинной записи на границе порций разобраны полностью и с верным номером строки
+	long := want.records[NewPrefix(78<<24, 16)]
+	if len(long) != 1 || long[0].Line != ziChunkLines || long[0].Domain != "long.example.com" {
+		t.Fatalf("boundary line records: %+v", long)
+	}
+
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(0))
+	for _, procs := range []int{1, 2, 8} {
+		runtime.GOMAXPROCS(procs)
+		for _, workers := range []int{0, 1, 2, 3, 16} {
+			// чтение обрывается посреди строки на границе первой порции
+			cut := boundary + 100
+			r := io.MultiReader(strings.NewReader(dump[:cut]), strings.NewReader(dump[cut:]))
+			if got := parseZI(t, newParser(workers), r); !reflect.DeepEqual(got, want) {
+				t.Errorf("GOMAXPROCS %d, workers %d: result differs from sequential parsing", procs, workers)
+			}
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package routes
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+)
+
+// Префикс подсети IPv4: адрес и длина маски
+type Prefix struct {
+	Addr uint32
+	Len  uint8
+}
+
+// Префикс подсети net.IPNet
+func PrefixOf(n *net.IPNet) Prefix {
+	ones, _ := n.Mask.Size()
+	return NewPrefix(binary.BigEndian.Uint32(n.IP.To4()), uint8(ones))
+}
+
+// Создание префикса с обнулением битов адреса за пределами маски
+func NewPrefix(addr uint32, length uint8) Prefix {
+	if length == 0 {
+		return Prefix{}
+	}
+	return Prefix{Addr: addr &^ (1<<(32-uint(length)) - 1), Len: length}
+}
+
+// Префикс, содержащий данный, с маской длины length
+func (p Prefix) Parent(length uint8) Prefix {
+	return NewPrefix(p.Addr, length)
+}
+
+// Проверка вхождения префикса o в текущий
+func (p Prefix) Contains(o Prefix) bool {
+	return o.Len >= p.Len && o.Parent(p.Len) == p
+}
+
+// Подсеть префикса
+func (p Prefix) IPNet() *net.IPNet {
+	ip := make(net.IP, 4)
+	binary.BigEndian.PutUint32(ip, p.Addr)
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(int(p.Len), 32)}
+}
+
+func (p Prefix) String() string {
+	return fmt.Sprintf("%d.%d.%d.%d/%d", byte(p.Addr>>24), byte(p.Addr>>16), byte(p.Addr>>8), byte(p.Addr), p.Len)
+}
@@ -0,0 +1,356 @@
+package routes
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Маршруты предыдущего запуска. Используются, чтобы при обновлении блоклиста без нужды не менять набор маршрутов.
+type PreviousRoutes struct {
+	Tolerance float64 // допустимая относительная разница штрафов, в пределах которой сохраняются прежние маршруты
+
+	prefixes   map[Prefix]struct{} // прежние маршруты
+	ancestors  map[Prefix]struct{} // подсети, содержащие прежние маршруты
+	exceptions []Prefix            // прежние исключения маршрутов, если они известны (см. SetExceptions)
+}
+
+// Создание набора прежних маршрутов
+func NewPreviousRoutes(nets []*net.IPNet, tolerance float64) *PreviousRoutes {
+	p := &PreviousRoutes{
+		Tolerance: tolerance,
+		prefixes:  make(map[Prefix]struct{}, len(nets)),
+		ancestors: make(map[Prefix]struct{}),
+	}
+	for _, n := range nets {
+		prefix := PrefixOf(n)
+		p.prefixes[prefix] = struct{}{}
+		for l := uint8(0); l < prefix.Len; l++ {
+			p.ancestors[prefix.Parent(l)] = struct{}{}
+		}
+	}
+	return p
+}
+
+// Проверка доли Tolerance: от 0 до 1 включительно, NaN недопустим
+func ValidTolerance(tolerance float64) bool {
+	return tolerance >= 0 && tolerance <= 1
+}
+
+// Загрузка прежних маршрутов из файла в любом из форматов вывода
+func LoadPreviousRoutes(path string, tolerance float64) (*PreviousRoutes, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	nets, exceptions, err := ParseRoutesWithExceptions(f)
+	if err != nil {
+		return nil, err
+	}
+	p := NewPreviousRoutes(nets, tolerance)
+	p.SetExceptions(exceptions)
+	return p, nil
+}
+
+// Задание прежних исключений маршрутов
+func (p *PreviousRoutes) SetExceptions(nets []*net.IPNet) {
+	p.exceptions = p.exceptions[:0]
+	for _, n := range nets {
+		p.exceptions = append(p.exceptions, PrefixOf(n))
+	}
+}
+
+// Диапазоны адресов формата nft нельзя однозначно собрать обратно в маршруты с исключениями
+var errNftRanges = errors.New("nft address ranges can't be parsed as routes, use routes in another format")
+
+// Разбор списка маршрутов в одном из форматов вывода: подсеть в виде CIDR либо адреса и маски.
+// Исключения (net_gateway, throw, nomatch) и команды удаления пропускаются.
+// Из форматов json и yaml берутся только префиксы маршрутов.
+func ParseRoutes(r io.Reader) ([]*net.IPNet, error) {
+	nets, _, err := ParseRoutesWithExceptions(r)
+	return nets, err
+}
+
+// Разбор списка маршрутов, как ParseRoutes, с исключениями маршрутов
+func ParseRoutesWithExceptions(r io.Reader) (nets, exceptions []*net.IPNet, err error) {
+	br := bufio.NewReader(r)
+	if b, _ := br.Peek(1); len(b) == 1 && b[0] == '{' {
+		return parseStructuredJSON(br)
+	}
+	yaml := false
+	for {
+		line, rerr := br.ReadString('\n')
+		if len(nets) == 0 && strings.HasPrefix(line, "generator: ") {
+			// формат yaml: параметры запуска содержат подсети, которые не являются маршрутами
+			yaml = true
+		}
+		if yaml {
+			if value := strings.TrimPrefix(line, "  - prefix: "); value != line {
+				n, err := parseStructuredPrefix(strings.TrimSpace(value))
+				if err != nil {
+					return nil, nil, err
+				}
+				nets = append(nets, n)
+			} else if value := strings.TrimPrefix(line, "      - "); value != line {
+				n, err := parseStructuredPrefix(strings.TrimSpace(value))
+				if err != nil {
+					return nil, nil, err
+				}
+				exceptions = append(exceptions, n)
+			}
+		} else if value, ok := cutAllowedIPs(line); ok {
+			// строка AllowedIPs формата wireguard содержит несколько подсетей
+			for _, f := range strings.Split(value, ",") {
+				if _, n, err := net.ParseCIDR(strings.TrimSpace(f)); err == nil && n.IP.To4() != nil {
+					nets = append(nets, n)
+				}
+			}
+		} else if elements, ok := cutNftElements(line); ok {
+			// строка add element формата nft содержит несколько подсетей и диапазонов
+			for _, f := range strings.Split(elements, ",") {
+				f = strings.TrimSpace(f)
+				if strings.Contains(f, "-") {
+					return nil, nil, errNftRanges
+				}
+				if _, n, err := net.ParseCIDR(f); err == nil && n.IP.To4() != nil {
+					nets = append(nets, n)
+				}
+			}
+		} else if n := parseRouteLine(line); n != nil && !isRemoveLine(line) {
+			if isExceptionLine(line) {
+				exceptions = append(exceptions, n)
+			} else {
+				nets = append(nets, n)
+			}
+		}
+		if rerr != nil {
+			if rerr == io.EOF {
+				break
+			}
+			return nil, nil, rerr
+		}
+	}
+	return nets, exceptions, nil
+}
+
+// Префиксы маршрутов и исключений формата json
+func parseStructuredJSON(r io.Reader) (nets, exceptions []*net.IPNet, err error) {
+	var out struct {
+		Routes []struct {
+			Prefix     string   `json:"prefix"`
+			Exceptions []string `json:"exceptions"`
+		} `json:"routes"`
+	}
+	if err := json.NewDecoder(r).Decode(&out); err != nil {
+		return nil, nil, err
+	}
+	nets = make([]*net.IPNet, 0, len(out.Routes))
+	for _, route := range out.Routes {
+		n, err := parseStructuredCIDR(route.Prefix)
+		if err != nil {
+			return nil, nil, err
+		}
+		nets = append(nets, n)
+		for _, e := range route.Exceptions {
+			n, err := parseStructuredCIDR(e)
+			if err != nil {
+				return nil, nil, err
+			}
+			exceptions = append(exceptions, n)
+		}
+	}
+	return nets, exceptions, nil
+}
+
+// Префикс маршрута формата yaml в кавычках
+func parseStructuredPrefix(value string) (*net.IPNet, error) {
+	prefix, err := strconv.Unquote(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid route prefix %s", value)
+	}
+	return parseStructuredCIDR(prefix)
+}
+
+func parseStructuredCIDR(prefix string) (*net.IPNet, error) {
+	_, n, err := net.ParseCIDR(prefix)
+	if err != nil || n.IP.To4() == nil {
+		return nil, fmt.Errorf("invalid route prefix %q", prefix)
+	}
+	return n, nil
+}
+
+// Строка исключения: маршрут мимо туннеля (ovpn), throw (iproute2) или nomatch (ipset)
+func isExceptionLine(line string) bool {
+	return strings.Contains(line, "net_gateway") || strings.Contains(line, " throw ") ||
+		strings.HasSuffix(strings.TrimSpace(line), " nomatch")
+}
+
+// Строка удаления маршрута: remove (RouterOS) или route delete (iproute2)
+func isRemoveLine(line string) bool {
+	return strings.HasPrefix(line, "remove ") || strings.HasPrefix(line, "route delete ")
+}
+
+// Элементы строки "add element ... { ... }"
+func cutNftElements(line string) (elements string, ok bool) {
+	if !strings.HasPrefix(line, "add element ") {
+		return "", false
+	}
+	start, end := strings.IndexByte(line, '{'), strings.LastIndexByte(line, '}')
+	if start < 0 || end < start {
+		return "", false
+	}
+	return line[start+1 : end], true
+}
+
+// Значение строки "AllowedIPs = ..."
+func cutAllowedIPs(line string) (value string, ok bool) {
+	line = strings.TrimSpace(line)
+	if !strings.HasPrefix(line, "AllowedIPs") {
+		return "", false
+	}
+	line = strings.TrimSpace(strings.TrimPrefix(line, "AllowedIPs"))
+	if !strings.HasPrefix(line, "=") {
+		return "", false
+	}
+	return line[1:], true
+}
+
+// Поиск подсети в строке маршрута
+func parseRouteLine(line string) *net.IPNet {
+	fields := strings.FieldsFunc(line, func(r rune) bool {
+		return r == ' ' || r == '\t' || r == '"' || r == ',' || r == '\r' || r == '\n'
+	})
+	for i, f := range fields {
+		if eq := strings.IndexByte(f, '='); eq >= 0 {
+			// параметр скрипта RouterOS: address=x.x.x.x[/y] или dst-address=x.x.x.x/y
+			if key, value := f[:eq], f[eq+1:]; key == "address" || key == "dst-address" {
+				if !strings.Contains(value, "/") {
+					value += "/32"
+				}
+				if _, n, err := net.ParseCIDR(value); err == nil && n.IP.To4() != nil {
+					return n
+				}
+			}
+			continue
+		}
+		if strings.Contains(f, "/") {
+			if _, n, err := net.ParseCIDR(f); err == nil && n.IP.To4() != nil {
+				return n
+			}
+			continue
+		}
+		ip := net.ParseIP(f).To4()
+		if ip == nil || i+1 >= len(fields) {
+			continue
+		}
+		if mask := net.ParseIP(fields[i+1]).To4(); mask != nil {
+			if ones, bits := net.IPMask(mask).Size(); bits == 32 {
+				return &net.IPNet{IP: ip.Mask(net.CIDRMask(ones, 32)), Mask: net.CIDRMask(ones, 32)}
+			}
+		}
+	}
+	return nil
+}
+
+// Ранг подсети при выборе очередной подсети для разбиения:
+// 2 - подсеть содержит прежние маршруты и её разбиение приближает к прежнему набору,
+// 1 - подсеть не связана с прежними маршрутами, 0 - подсеть является прежним маршрутом.
+func (p *PreviousRoutes) rank(node optimizeNode) int {
+	prefix := node.Prefix()
+	if _, ok := p.prefixes[prefix]; ok {
+		return 0
+	}
+	if _, ok := p.ancestors[prefix]; ok {
+		return 2
+	}
+	return 1
+}
+
+// Выбор подсети для разбиения. Среди подсетей, штраф которых отличается от наибольшего не более чем на долю Tolerance,
+// выбирается подсеть с наибольшим рангом.
+func (p *PreviousRoutes) pop(l *IPTreeNodesList) optimizeNode {
+	if p == nil || l.Size() == 0 {
+		return l.popAt(0)
+	}
+	top := l.nodes[0]
+	if top.forced() || top.leaf() {
+		return l.popAt(0)
+	}
+
+	threshold := float64(top.Penalty()) * (1 - p.Tolerance)
+	best, bestRank := 0, p.rank(top)
+	for i := 1; i < len(l.nodes) && float64(l.nodes[i].Penalty()) >= threshold; i++ {
+		// листья не разбиваются, их выбор завершил бы оптимизацию
+		if l.nodes[i].leaf() {
+			continue
+		}
+		if r := p.rank(l.nodes[i]); r > bestRank {
+			best, bestRank = i, r
+		}
+	}
+	return l.popAt(best)
+}
+
+// Сравнение итоговых маршрутов с прежними
+func (p *PreviousRoutes) Diff(routes []*Route) (added, removed []*net.IPNet) {
+	current := make(map[Prefix]struct{}, len(routes))
+	for _, r := range routes {
+		prefix := r.Node.Prefix()
+		current[prefix] = struct{}{}
+		if _, ok := p.prefixes[prefix]; !ok {
+			added = append(added, prefix.IPNet())
+		}
+	}
+	prefixes := make([]Prefix, 0)
+	for prefix := range p.prefixes {
+		if _, ok := current[prefix]; !ok {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	sort.Slice(prefixes, func(i, j int) bool {
+		if prefixes[i].Addr != prefixes[j].Addr {
+			return prefixes[i].Addr < prefixes[j].Addr
+		}
+		return prefixes[i].Len < prefixes[j].Len
+	})
+	for _, prefix := range prefixes {
+		removed = append(removed, prefix.IPNet())
+	}
+	return
+}
+
+// Прежние исключения, которых нет среди исключений итоговых маршрутов
+func (p *PreviousRoutes) RemovedExceptions(routes []*Route) (removed []*net.IPNet) {
+	current := make(map[Prefix]struct{})
+	for _, r := range routes {
+		for _, e := range r.Exceptions {
+			current[PrefixOf(e)] = struct{}{}
+		}
+	}
+	prefixes := make([]Prefix, 0)
+	for _, prefix := range p.exceptions {
+		if _, ok := current[prefix]; !ok {
+			current[prefix] = struct{}{}
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	sort.Slice(prefixes, func(i, j int) bool {
+		if prefixes[i].Addr != prefixes[j].Addr {
+			return prefixes[i].Addr < prefixes[j].Addr
+		}
+		return prefixes[i].Len < prefixes[j].Len
+	})
+	for _, prefix := range prefixes {
+		removed = append(removed, prefix.IPNet())
+	}
+	return
+}
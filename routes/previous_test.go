@@ -0,0 +1,199 @@
+package routes
+
+import (
+	"bytes"
+	"math"
+	"net"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// Маршруты из подсетей в CIDR, исключения указываются после подсети маршрута через "!"
+func testRoutes(t *testing.T, specs ...string) []*Route {
+	var routes []*Route
+	for _, spec := range specs {
+		parts := strings.Split(spec, "!")
+		_, n, err := net.ParseCIDR(parts[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := &Route{Node: NewIPTree([]*net.IPNet{n}).Leaves()[0]}
+		for _, e := range parts[1:] {
+			_, en, err := net.ParseCIDR(e)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r.Exceptions = append(r.Exceptions, en)
+		}
+		routes = append(routes, r)
+	}
+	return routes
+}
+
+func sortedStrings(nets []*net.IPNet) []string {
+	s := make([]string, 0, len(nets))
+	for _, n := range nets {
+		s = append(s, n.String())
+	}
+	sort.Strings(s)
+	return s
+}
+
+// Вывод маршрутов в формате и разбор его как -previous
+func roundTrip(t *testing.T, routes []*Route, opts OutputOptions) []string {
+	var buf bytes.Buffer
+	if err := OutputNets(&buf, routes, opts); err != nil {
+		t.Fatalf("%s: %s", opts.Format, err)
+	}
+	nets, err := ParseRoutes(&buf)
+	if err != nil {
+		t.Fatalf("%s: %s", opts.Format, err)
+	}
+	return sortedStrings(nets)
+}
+
+func TestParseRoutesIPRoute2(t *testing.T) {
+	routes := testRoutes(t, "1.2.0.0/16!1.2.3.0/24", "5.6.7.0/24")
+	want := []string{"1.2.0.0/16", "5.6.7.0/24"}
+
+	opts := OutputOptions{Format: "iproute2", IPRoute2: IPRoute2Options{Dev: "tun0", Table: "100"}}
+	if got := roundTrip(t, routes, opts); !reflect.DeepEqual(got, want) {
+		t.Errorf("iproute2: got %v, want %v", got, want)
+	}
+
+	// маршруты, удаленные с прошлого запуска, не возвращаются в прежний набор
+	_, prev, _ := net.ParseCIDR("9.9.9.0/24")
+	opts.IPRoute2.Cleanup = "delete"
+	opts.Previous = NewPreviousRoutes([]*net.IPNet{prev}, 0)
+	if got := roundTrip(t, routes, opts); !reflect.DeepEqual(got, want) {
+		t.Errorf("iproute2 delete: got %v, want %v", got, want)
+	}
+}
+
+func TestParseRoutesSkipsRemoved(t *testing.T) {
+	nets, err := ParseRoutes(strings.NewReader("route replace 1.2.0.0/16 dev tun0\nroute delete 9.9.9.0/24\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := sortedStrings(nets), []string{"1.2.0.0/16"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestParseRoutesSets(t *testing.T) {
+	routes := testRoutes(t, "1.2.0.0/16!1.2.3.0/24", "5.6.7.0/24", "8.8.8.8/32")
+	want := []string{"1.2.0.0/16", "5.6.7.0/24", "8.8.8.8/32"}
+	if got := roundTrip(t, routes, OutputOptions{Format: "ipset"}); !reflect.DeepEqual(got, want) {
+		t.Errorf("ipset: got %v, want %v", got, want)
+	}
+
+	// несколько команд add element по nftElementsPerCommand элементов
+	var specs, wantNft []string
+	for i := 0; i < nftElementsPerCommand+10; i++ {
+		cidr := ipv4String(0x0a000000+uint32(i)<<8) + "/24"
+		specs = append(specs, cidr)
+		wantNft = append(wantNft, cidr)
+	}
+	sort.Strings(wantNft)
+	if got := roundTrip(t, testRoutes(t, specs...), OutputOptions{Format: "nft"}); !reflect.DeepEqual(got, wantNft) {
+		t.Errorf("nft: got %d nets, want %d", len(got), len(wantNft))
+	}
+
+	// маршрут с исключениями выводится диапазонами, которые не разбираются как маршруты
+	var buf bytes.Buffer
+	if err := OutputNets(&buf, routes, OutputOptions{Format: "nft"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseRoutes(&buf); err != errNftRanges {
+		t.Errorf("nft ranges: got error %v, want %v", err, errNftRanges)
+	}
+}
+
+func TestParseRoutesRouterOS(t *testing.T) {
+	routes := testRoutes(t, "1.2.0.0/16", "5.6.7.0/24", "8.8.8.8/32")
+	want := []string{"1.2.0.0/16", "5.6.7.0/24", "8.8.8.8/32"}
+	for _, format := range []string{"routeros-address-list", "routeros-route"} {
+		opts := OutputOptions{Format: format, RouterOS: RouterOSOptions{Gateway: "10.0.0.1"}}
+		if got := roundTrip(t, routes, opts); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %v, want %v", format, got, want)
+		}
+
+		// скрипт режима diff содержит полный набор маршрутов, а не только изменения
+		_, prev, _ := net.ParseCIDR("9.9.9.0/24")
+		opts.RouterOS.Diff = true
+		opts.Previous = NewPreviousRoutes([]*net.IPNet{prev, routes[0].Network()}, 0)
+		if got := roundTrip(t, routes, opts); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s diff: got %v, want %v", format, got, want)
+		}
+	}
+}
+
+func TestParseRoutesStructured(t *testing.T) {
+	routes := testRoutes(t, "1.2.0.0/16!1.2.3.0/24", "5.6.7.0/24")
+	want := []string{"1.2.0.0/16", "5.6.7.0/24"}
+	// подсети в параметрах запуска и исключениях не являются маршрутами
+	meta := OutputMeta{Options: map[string]string{"exclude": "206.0.0.0/8"}}
+	for _, format := range []string{"json", "yaml"} {
+		if got := roundTrip(t, routes, OutputOptions{Format: format, Meta: meta}); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %v, want %v", format, got, want)
+		}
+		if got := roundTrip(t, nil, OutputOptions{Format: format, Meta: meta}); len(got) != 0 {
+			t.Errorf("%s without routes: got %v", format, got)
+		}
+	}
+}
+
+func TestIPRoute2DeleteExceptions(t *testing.T) {
+	opts := OutputOptions{Format: "iproute2", IPRoute2: IPRoute2Options{Dev: "tun0", Table: "100"}}
+	var prev bytes.Buffer
+	if err := OutputNets(&prev, testRoutes(t, "1.2.0.0/16!1.2.3.0/24!1.2.4.0/24", "5.6.7.0/24"), opts); err != nil {
+		t.Fatal(err)
+	}
+	nets, exceptions, err := ParseRoutesWithExceptions(&prev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := sortedStrings(exceptions), []string{"1.2.3.0/24", "1.2.4.0/24"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("exceptions: got %v, want %v", got, want)
+	}
+
+	// исчезнувшее исключение удаляется, сохранившееся остается
+	opts.IPRoute2.Cleanup = "delete"
+	opts.Previous = NewPreviousRoutes(nets, 0)
+	opts.Previous.SetExceptions(exceptions)
+	var buf bytes.Buffer
+	if err := OutputNets(&buf, testRoutes(t, "1.2.0.0/16!1.2.3.0/24", "5.6.7.0/24"), opts); err != nil {
+		t.Fatal(err)
+	}
+	var deletes []string
+	for _, line := range strings.Split(buf.String(), "\n") {
+		if strings.HasPrefix(line, "route delete ") {
+			deletes = append(deletes, line)
+		}
+	}
+	if want := []string{"route delete throw 1.2.4.0/24 table 100"}; !reflect.DeepEqual(deletes, want) {
+		t.Errorf("got %q, want %q", deletes, want)
+	}
+}
+
+func TestValidTolerance(t *testing.T) {
+	for _, tc := range []struct {
+		tolerance float64
+		valid     bool
+	}{
+		{0, true},
+		{0.1, true},
+		{1, true},
+		{-0.1, false},
+		{1.01, false},
+		{math.NaN(), false},
+		{math.Inf(1), false},
+		{math.Inf(-1), false},
+	} {
+		if got := ValidTolerance(tc.tolerance); got != tc.valid {
+			t.Errorf("ValidTolerance(%v) = %v, want %v", tc.tolerance, got, tc.valid)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package routes
+
+import (
+	"sort"
+)
+
+// Исходная запись блоклиста
+type Record struct {
+	Line     int    `json:"line"`     // номер строки в блоклисте
+	IPs      string `json:"ips"`      // поле адресов как есть
+	Domain   string `json:"domain"`   // заблокированный домен
+	URL      string `json:"url"`      // заблокированный URL
+	Org      string `json:"org"`      // орган, принявший решение о блокировке
+	Decision string `json:"decision"` // номер решения
+	Date     string `json:"date"`     // дата решения
+}
+
+// Индекс исходных записей по префиксам заблокированных адресов и подсетей
+type RecordIndex struct {
+	records map[Prefix][]*Record
+}
+
+// Создание пустого индекса
+func NewRecordIndex() *RecordIndex {
+	return &RecordIndex{records: make(map[Prefix][]*Record)}
+}
+
+// Добавление записи, заблокировавшей префикс p
+func (ri *RecordIndex) Add(p Prefix, rec *Record) {
+	recs := ri.records[p]
+	if len(recs) > 0 && recs[len(recs)-1] == rec {
+		return
+	}
+	ri.records[p] = append(recs, rec)
+}
+
+// Записи, заблокировавшие префикс p
+func (ri *RecordIndex) Get(p Prefix) []*Record {
+	return ri.records[p]
+}
+
+// Записи, заблокировавшие адрес ip: отдельно или в составе подсети
+func (ri *RecordIndex) Lookup(ip uint32) (recs []*Record) {
+	for l := 0; l <= 32; l++ {
+		recs = append(recs, ri.records[NewPrefix(ip, uint8(l))]...)
+	}
+	return sortRecords(recs)
+}
+
+// Записи, заблокировавшие адреса и подсети внутри префикса p
+func (ri *RecordIndex) Within(p Prefix) (recs []*Record) {
+	for rp, rr := range ri.records {
+		if p.Contains(rp) {
+			recs = append(recs, rr...)
+		}
+	}
+	return sortRecords(recs)
+}
+
+// Упорядочивание записей по номеру строки с удалением повторов
+func sortRecords(recs []*Record) []*Record {
+	sort.Slice(recs, func(i, j int) bool {
+		return recs[i].Line < recs[j].Line
+	})
+	uniq := recs[:0]
+	for i, r := range recs {
+		if i == 0 || r != recs[i-1] {
+			uniq = append(uniq, r)
+		}
+	}
+	return uniq
+}
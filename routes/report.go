@@ -0,0 +1,90 @@
+package routes
+
+import (
+	"encoding/json"
+	"io/ioutil"
+)
+
+// Отчет о качестве оптимизации
+type Report struct {
+	Totals        ReportTotals  `json:"totals"`
+	Churn         *ReportChurn  `json:"churn,omitempty"` // изменения относительно предыдущего запуска
+	PrefixLengths map[int]uint  `json:"prefix_lengths"`  // кол-во маршрутов по длине префикса
+	Routes        []ReportRoute `json:"routes"`
+}
+
+// Изменения маршрутов относительно предыдущего запуска
+type ReportChurn struct {
+	Added   int `json:"added"`
+	Removed int `json:"removed"`
+}
+
+// Итоговые показатели отчета
+type ReportTotals struct {
+	Routes          int     `json:"routes"`
+	Exceptions      int     `json:"exceptions"`
+	Blocked         uint64  `json:"blocked"`          // заблокированные адреса, попавшие в маршруты
+	Covered         uint64  `json:"covered"`          // все адреса, попавшие в маршруты
+	Collateral      uint64  `json:"collateral"`       // незаблокированные адреса, попавшие в маршруты
+	CollateralRatio float64 `json:"collateral_ratio"` // доля незаблокированных адресов среди попавших в маршруты
+	Excluded        uint64  `json:"excluded"`         // заблокированные адреса, фактически удаленные исключаемыми подсетями
+}
+
+// Показатели отдельного маршрута
+type ReportRoute struct {
+	Prefix     string   `json:"prefix"`
+	Blocked    uint32   `json:"blocked"`
+	Collateral uint32   `json:"collateral"`
+	Penalty    uint32   `json:"penalty"`
+	Exceptions []string `json:"exceptions,omitempty"`
+}
+
+// Формирование отчета по итоговым маршрутам.
+// blockedBefore - кол-во заблокированных адресов в дереве до исключения подсетей.
+func NewReport(routes []*Route, blockedBefore uint64) *Report {
+	r := &Report{
+		PrefixLengths: make(map[int]uint),
+		Routes:        make([]ReportRoute, 0, len(routes)),
+	}
+
+	for _, route := range routes {
+		node := route.Node
+		covered := route.Covered()
+		exceptions := make([]string, 0, len(route.Exceptions))
+		for _, e := range route.Exceptions {
+			exceptions = append(exceptions, e.String())
+		}
+
+		r.Routes = append(r.Routes, ReportRoute{
+			Prefix:     route.Network().String(),
+			Blocked:    node.SubtreeSize,
+			Collateral: covered - node.SubtreeSize,
+			Penalty:    node.Penalty(),
+			Exceptions: exceptions,
+		})
+		r.PrefixLengths[int(node.MaskSize)]++
+
+		r.Totals.Routes++
+		r.Totals.Exceptions += len(route.Exceptions)
+		r.Totals.Blocked += uint64(node.SubtreeSize)
+		r.Totals.Covered += uint64(covered)
+	}
+
+	r.Totals.Collateral = r.Totals.Covered - r.Totals.Blocked
+	if r.Totals.Covered > 0 {
+		r.Totals.CollateralRatio = float64(r.Totals.Collateral) / float64(r.Totals.Covered)
+	}
+	if blockedBefore > r.Totals.Blocked {
+		r.Totals.Excluded = blockedBefore - r.Totals.Blocked
+	}
+	return r
+}
+
+// Запись отчета в файл в формате JSON
+func (r *Report) WriteFile(path string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
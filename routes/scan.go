@@ -0,0 +1,153 @@
+package routes
+
+import (
+	"math/bits"
+)
+
+// Сканер адресов IPv4, подсетей в CIDR и диапазонов "a.b.c.d-e.f.g.h" в поле адресов блоклиста.
+// Разбирает строку за один проход без выделения памяти, диапазоны разбиваются на минимальный набор подсетей.
+type ipScanner struct {
+	s   string
+	pos int
+
+	// остаток разбиваемого диапазона
+	rangeLo, rangeHi uint32
+	inRange          bool
+}
+
+func newIPScanner(s string) ipScanner {
+	return ipScanner{s: s}
+}
+
+// Следующий префикс в строке. ok будет false, когда строка закончилась.
+func (sc *ipScanner) Next() (p Prefix, ok bool) {
+	for {
+		if sc.inRange {
+			return sc.nextRangePrefix(), true
+		}
+		if !sc.scan() {
+			return Prefix{}, false
+		}
+		if !sc.inRange {
+			return NewPrefix(sc.rangeLo, uint8(sc.rangeHi)), true
+		}
+	}
+}
+
+// Поиск следующего элемента. Адрес или подсеть возвращаются в rangeLo и rangeHi (длина маски),
+// для диапазона устанавливается inRange.
+func (sc *ipScanner) scan() bool {
+	s := sc.s
+	for sc.pos < len(s) {
+		start := sc.pos
+		if !isDigit(s[start]) || start > 0 && (isDigit(s[start-1]) || s[start-1] == '.') {
+			sc.pos++
+			continue
+		}
+
+		ip, n := scanIPv4(s[start:])
+		if n == 0 {
+			sc.skipToken()
+			continue
+		}
+		end := start + n
+
+		switch {
+		case end < len(s) && s[end] == '/':
+			length, m := scanDecimal(s[end+1:], 2)
+			end += 1 + m
+			if m == 0 || length > 32 || !sc.boundary(end) {
+				sc.pos = end
+				sc.skipToken()
+				continue
+			}
+			sc.pos = end
+			sc.rangeLo, sc.rangeHi = ip, uint32(length)
+			return true
+		case end < len(s) && s[end] == '-':
+			hi, m := scanIPv4(s[end+1:])
+			end += 1 + m
+			if m == 0 || hi < ip || !sc.boundary(end) {
+				sc.pos = end
+				sc.skipToken()
+				continue
+			}
+			sc.pos = end
+			sc.rangeLo, sc.rangeHi, sc.inRange = ip, hi, true
+			return true
+		case !sc.boundary(end):
+			sc.pos = end
+			sc.skipToken()
+			continue
+		}
+		sc.pos = end
+		sc.rangeLo, sc.rangeHi = ip, 32
+		return true
+	}
+	return false
+}
+
+// Элемент заканчивается на границе строки или символе, не являющемся частью адреса
+func (sc *ipScanner) boundary(end int) bool {
+	return end >= len(sc.s) || !isDigit(sc.s[end]) && sc.s[end] != '.' && sc.s[end] != '/' && sc.s[end] != '-'
+}
+
+// Пропуск остатка ошибочного элемента, чтобы не разбирать его хвост как отдельный адрес
+func (sc *ipScanner) skipToken() {
+	for sc.pos < len(sc.s) && !sc.boundary(sc.pos) {
+		sc.pos++
+	}
+}
+
+// Очередная подсеть диапазона: наибольшая выровненная подсеть, начинающаяся с rangeLo и не выходящая за rangeHi
+func (sc *ipScanner) nextRangePrefix() Prefix {
+	lo, hi := sc.rangeLo, sc.rangeHi
+	length := 32 - bits.TrailingZeros32(lo)
+	for length < 32 && uint64(lo)+(uint64(1)<<(32-uint(length)))-1 > uint64(hi) {
+		length++
+	}
+	next := uint64(lo) + uint64(1)<<(32-uint(length))
+	if next > uint64(hi) {
+		sc.inRange = false
+	} else {
+		sc.rangeLo = uint32(next)
+	}
+	return Prefix{Addr: lo, Len: uint8(length)}
+}
+
+// Разбор адреса IPv4 в начале s. n - длина разобранной части, 0 при ошибке.
+// Октеты больше 255 и с ведущими нулями считаются ошибкой.
+func scanIPv4(s string) (ip uint32, n int) {
+	for i := 0; i < 4; i++ {
+		if i > 0 {
+			if n >= len(s) || s[n] != '.' {
+				return 0, 0
+			}
+			n++
+		}
+		octet, m := scanDecimal(s[n:], 3)
+		if m == 0 || octet > 255 {
+			return 0, 0
+		}
+		ip = ip<<8 | uint32(octet)
+		n += m
+	}
+	return ip, n
+}
+
+// Разбор десятичного числа не длиннее maxDigits цифр в начале s. Ведущие нули не допускаются.
+// m - длина разобранной части, 0 при ошибке.
+func scanDecimal(s string, maxDigits int) (v int, m int) {
+	for m < len(s) && isDigit(s[m]) {
+		if m == maxDigits || m == 1 && s[0] == '0' {
+			return 0, 0
+		}
+		v = v*10 + int(s[m]-'0')
+		m++
+	}
+	return v, m
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
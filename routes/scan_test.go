@@ -0,0 +1,106 @@
+package routes
+
+import (
+	"net"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func scanAll(s string) []string {
+	var got []string
+	sc := newIPScanner(s)
+	for p, ok := sc.Next(); ok; p, ok = sc.Next() {
+		got = append(got, p.String())
+	}
+	return got
+}
+
+func TestIPScanner(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"1.2.3.4", []string{"1.2.3.4/32"}},
+		{"1.2.3.0/24", []string{"1.2.3.0/24"}},
+		{"1.2.3.4 | 5.6.7.0/24", []string{"1.2.3.4/32", "5.6.7.0/24"}},
+		{"1.2.3.5/24", []string{"1.2.3.0/24"}},
+		{"0.0.0.0/0", []string{"0.0.0.0/0"}},
+		{"255.255.255.255", []string{"255.255.255.255/32"}},
+		// октет больше 255
+		{"256.1.1.1", nil},
+		{"1.2.3.256", nil},
+		// ведущие нули
+		{"01.2.3.4", nil},
+		{"1.2.3.04", nil},
+		{"1.2.3.0/024", nil},
+		// маска длиннее 32
+		{"1.2.3.4/33", nil},
+		// больше четырех октетов
+		{"1.2.3.4.5", nil},
+		{"1.2.3.4.5, 6.7.8.9", []string{"6.7.8.9/32"}},
+		// диапазоны разбиваются на минимальный набор подсетей
+		{"1.2.3.0-1.2.3.255", []string{"1.2.3.0/24"}},
+		{"1.2.3.0-1.2.3.10", []string{"1.2.3.0/29", "1.2.3.8/31", "1.2.3.10/32"}},
+		{"1.2.3.5-1.2.3.8", []string{"1.2.3.5/32", "1.2.3.6/31", "1.2.3.8/32"}},
+		{"1.2.3.4-1.2.3.4", []string{"1.2.3.4/32"}},
+		{"0.0.0.0-255.255.255.255", []string{"0.0.0.0/0"}},
+		{"", nil},
+	}
+	for _, tt := range tests {
+		if got := scanAll(tt.in); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: got %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestIPScannerAllocs(t *testing.T) {
+	allocs := testing.AllocsPerRun(100, func() {
+		sc := newIPScanner("1.2.3.4 | 5.6.7.0/24 | 8.8.8.0-8.8.8.10")
+		for _, ok := sc.Next(); ok; _, ok = sc.Next() {
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("got %v allocations, want 0", allocs)
+	}
+}
+
+// Поле адресов блоклиста для бенчмарков
+var benchIPPart = strings.Repeat("91.108.4.0/22 | 149.154.167.99 | 5.6.7.8 | ", 4) + "8.8.8.8"
+
+// Прежний разбор поля адресов регулярными выражениями
+var (
+	benchRegexpCIDR = regexp.MustCompile(`(?:\d{1,3}\.){3}\d{1,3}\/\d{1,2}`)
+	benchRegexpIP   = regexp.MustCompile(`(?:\d{1,3}\.){3}\d{1,3}`)
+)
+
+func regexpScan(ipPart string) (ips []net.IP, nets []*net.IPNet) {
+	for _, match := range benchRegexpCIDR.FindAllString(ipPart, -1) {
+		if _, n, err := net.ParseCIDR(match); err == nil {
+			nets = append(nets, n)
+		}
+	}
+	for _, match := range benchRegexpIP.FindAllString(ipPart, -1) {
+		if ip := net.ParseIP(match); ip != nil {
+			ips = append(ips, ip.To4())
+		}
+	}
+	return
+}
+
+func BenchmarkIPScanner(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		sc := newIPScanner(benchIPPart)
+		for _, ok := sc.Next(); ok; _, ok = sc.Next() {
+		}
+	}
+}
+
+func BenchmarkRegexpScan(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		regexpScan(benchIPPart)
+	}
+}
@@ -0,0 +1,71 @@
+package routes
+
+import (
+	"net"
+
+	"github.com/amkulikov/ipv4range"
+)
+
+// Построение дерева из списка подсетей
+func NewIPTree(nets []*net.IPNet) *IPTreeNode {
+	root := &IPTreeNode{}
+	for _, n := range nets {
+		root.AddSubnet(n)
+	}
+	return root
+}
+
+// Подсети листьев дерева в порядке возрастания адресов
+func (t *IPTreeNode) LeafNets() []*net.IPNet {
+	leaves := t.Leaves()
+	nets := make([]*net.IPNet, 0, len(leaves))
+	for _, l := range leaves {
+		nets = append(nets, l.Prefix().IPNet())
+	}
+	return nets
+}
+
+// Поиск узла с префиксом p. nil, если такого узла нет.
+func (t *IPTreeNode) find(p Prefix) *IPTreeNode {
+	node := t
+	for node != nil && node.MaskSize < p.Len {
+		if p.Addr&(1<<(31-node.MaskSize)) != 0 {
+			node = node.One
+		} else {
+			node = node.Zero
+		}
+	}
+	return node
+}
+
+// Объединение: новое дерево с адресами, входящими хотя бы в одно из деревьев
+func (t *IPTreeNode) Union(o *IPTreeNode) *IPTreeNode {
+	return NewIPTree(append(t.LeafNets(), o.LeafNets()...))
+}
+
+// Разность: новое дерево с адресами t, не входящими в o
+func (t *IPTreeNode) Difference(o *IPTreeNode) *IPTreeNode {
+	root := NewIPTree(t.LeafNets())
+	for _, n := range o.LeafNets() {
+		root.RemoveSubnet(n)
+	}
+	return root
+}
+
+// Пересечение: новое дерево с адресами, входящими в оба дерева
+func (t *IPTreeNode) Intersect(o *IPTreeNode) *IPTreeNode {
+	nets := make([]*net.IPNet, 0)
+	for _, leaf := range t.Leaves() {
+		p := leaf.Prefix()
+		// лист целиком входит в подсеть o
+		if match := o.LongestMatch(ipv4range.IPv4(p.Addr)); match.IsLeaf && match.MaskSize <= p.Len {
+			nets = append(nets, p.IPNet())
+			continue
+		}
+		// иначе берем подсети o, лежащие внутри листа
+		if node := o.find(p); node != nil {
+			nets = append(nets, node.LeafNets()...)
+		}
+	}
+	return NewIPTree(nets)
+}
@@ -0,0 +1,144 @@
+package routes
+
+import (
+	"reflect"
+	"testing"
+)
+
+// Подсети листьев дерева в CIDR
+func leafStrings(t *IPTreeNode) (s []string) {
+	for _, n := range t.LeafNets() {
+		s = append(s, n.String())
+	}
+	return
+}
+
+// Подсети, остающиеся от подсети outer после удаления адреса addr (оба в CIDR), в порядке возрастания
+func withoutAddress(t *testing.T, outer, addr string) []string {
+	o, a := PrefixOf(mustCIDRs(t, outer)[0]), PrefixOf(mustCIDRs(t, addr)[0]).Addr
+	var siblings []string
+	for l := o.Len + 1; l <= 32; l++ {
+		siblings = append(siblings, NewPrefix(a^(1<<(32-uint(l))), l).String())
+	}
+	return leafStrings(testTree(t, siblings...))
+}
+
+func TestSetOperations(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		a, b      []string
+		union     []string
+		intersect []string
+		aMinusB   []string
+		bMinusA   []string
+	}{
+		{
+			name:      "disjoint",
+			a:         []string{"1.2.3.4/32"},
+			b:         []string{"5.6.7.8/32"},
+			union:     []string{"1.2.3.4/32", "5.6.7.8/32"},
+			intersect: nil,
+			aMinusB:   []string{"1.2.3.4/32"},
+			bMinusA:   []string{"5.6.7.8/32"},
+		},
+		{
+			name:      "overlapping /8 and /32",
+			a:         []string{"10.0.0.0/8", "1.2.3.4/32"},
+			b:         []string{"10.1.2.3/32", "1.2.3.4/32", "11.0.0.1/32"},
+			union:     []string{"1.2.3.4/32", "10.0.0.0/8", "11.0.0.1/32"},
+			intersect: []string{"1.2.3.4/32", "10.1.2.3/32"},
+			aMinusB:   withoutAddress(t, "10.0.0.0/8", "10.1.2.3/32"),
+			bMinusA:   []string{"11.0.0.1/32"},
+		},
+		{
+			name:      "/32 inside /24",
+			a:         []string{"10.0.0.0/24"},
+			b:         []string{"10.0.0.5/32"},
+			union:     []string{"10.0.0.0/24"},
+			intersect: []string{"10.0.0.5/32"},
+			aMinusB: []string{"10.0.0.0/30", "10.0.0.4/32", "10.0.0.6/31", "10.0.0.8/29",
+				"10.0.0.16/28", "10.0.0.32/27", "10.0.0.64/26", "10.0.0.128/25"},
+			bMinusA: nil,
+		},
+		{
+			name:      "nested subnets",
+			a:         []string{"10.0.0.0/8"},
+			b:         []string{"10.128.0.0/9", "10.1.0.0/16", "12.0.0.0/8"},
+			union:     []string{"10.0.0.0/8", "12.0.0.0/8"},
+			intersect: []string{"10.1.0.0/16", "10.128.0.0/9"},
+			aMinusB:   []string{"10.0.0.0/16", "10.2.0.0/15", "10.4.0.0/14", "10.8.0.0/13", "10.16.0.0/12", "10.32.0.0/11", "10.64.0.0/10"},
+			bMinusA:   []string{"12.0.0.0/8"},
+		},
+		{
+			name:      "adjacent halves",
+			a:         []string{"10.0.0.0/25"},
+			b:         []string{"10.0.0.128/25"},
+			union:     []string{"10.0.0.0/25", "10.0.0.128/25"},
+			intersect: nil,
+			aMinusB:   []string{"10.0.0.0/25"},
+			bMinusA:   []string{"10.0.0.128/25"},
+		},
+		{
+			name:      "equal",
+			a:         []string{"1.2.3.0/24", "5.5.5.5/32"},
+			b:         []string{"5.5.5.5/32", "1.2.3.0/24"},
+			union:     []string{"1.2.3.0/24", "5.5.5.5/32"},
+			intersect: []string{"1.2.3.0/24", "5.5.5.5/32"},
+			aMinusB:   nil,
+			bMinusA:   nil,
+		},
+		{
+			name:      "empty operand",
+			a:         []string{"1.2.3.4/32", "10.0.0.0/8"},
+			b:         nil,
+			union:     []string{"1.2.3.4/32", "10.0.0.0/8"},
+			intersect: nil,
+			aMinusB:   []string{"1.2.3.4/32", "10.0.0.0/8"},
+			bMinusA:   nil,
+		},
+		{
+			name: "both empty",
+		},
+	} {
+		a, b := testTree(t, tc.a...), testTree(t, tc.b...)
+		aLeaves, bLeaves := leafStrings(a), leafStrings(b)
+
+		results := []struct {
+			op   string
+			got  *IPTreeNode
+			want []string
+		}{
+			{"a|b", a.Union(b), tc.union},
+			{"b|a", b.Union(a), tc.union},
+			{"a&b", a.Intersect(b), tc.intersect},
+			{"b&a", b.Intersect(a), tc.intersect},
+			{"a-b", a.Difference(b), tc.aMinusB},
+			{"b-a", b.Difference(a), tc.bMinusA},
+		}
+		sizes := make(map[string]uint32)
+		for _, r := range results {
+			if got := leafStrings(r.got); !reflect.DeepEqual(got, r.want) {
+				t.Errorf("%s: %s = %v, want %v", tc.name, r.op, got, r.want)
+			}
+			// счетчики результата совпадают с деревом, построенным заново
+			if d := diffTrees(r.got, testTree(t, r.want...)); d != "" {
+				t.Errorf("%s: %s: %s", tc.name, r.op, d)
+			}
+			sizes[r.op] = r.got.SubtreeSize
+		}
+
+		// кол-во адресов: |a|b| + |a&b| = |a| + |b|, |a-b| + |a&b| = |a|
+		if sizes["a|b"]+sizes["a&b"] != a.SubtreeSize+b.SubtreeSize {
+			t.Errorf("%s: |a|b| %d + |a&b| %d != |a| %d + |b| %d", tc.name, sizes["a|b"], sizes["a&b"], a.SubtreeSize, b.SubtreeSize)
+		}
+		if sizes["a-b"]+sizes["a&b"] != a.SubtreeSize || sizes["b-a"]+sizes["a&b"] != b.SubtreeSize {
+			t.Errorf("%s: |a-b| %d, |b-a| %d and |a&b| %d don't add up to |a| %d and |b| %d",
+				tc.name, sizes["a-b"], sizes["b-a"], sizes["a&b"], a.SubtreeSize, b.SubtreeSize)
+		}
+
+		// операции не изменяют операнды
+		if !reflect.DeepEqual(leafStrings(a), aLeaves) || !reflect.DeepEqual(leafStrings(b), bLeaves) {
+			t.Errorf("%s: operands changed", tc.name)
+		}
+	}
+}
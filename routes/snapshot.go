@@ -0,0 +1,376 @@
+package routes
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+)
+
+// Бинарный снимок разобранного блоклиста.
+//
+// Формат (целые числа big endian):
+//
+//	"BRSN", версия uint16, флаги uint16
+//	при флаге snapshotUpdated: время формирования блоклиста (длина uvarint, байты)
+//	при флаге snapshotDomains: кол-во доменов uint32, домены (длина uvarint, байты)
+//	кол-во префиксов uint32, префиксы по возрастанию: адрес uint32, длина маски uint8
+//	при флаге snapshotRecords:
+//	  размер раздела записей в байтах uint32 (с версии 3)
+//	  кол-во записей uint32, записи: номер строки uint32 и 6 строк (длина uvarint, байты)
+//	  кол-во ссылок uint32, ссылки: адрес uint32, длина маски uint8, номер записи uint32
+//	CRC32 (IEEE) всего предшествующего содержимого uint32
+const (
+	snapshotMagic = "BRSN"
+	// версия 2 добавила флаги snapshotUpdated и snapshotDomains,
+	// версия 3 - размер раздела записей, чтобы разбирать записи только при обращении к ним
+	snapshotVersion = 3
+
+	// флаги, известные текущей версии; снимок с другими флагами не читается
+	snapshotKnownFlags = snapshotRecords | snapshotUpdated | snapshotDomains
+
+	snapshotRecords = 1 << 0 // снимок содержит исходные записи
+	snapshotUpdated = 1 << 1 // снимок содержит время формирования блоклиста
+	snapshotDomains = 1 << 2 // снимок содержит домены блоклиста
+)
+
+// Проверка, что данные начинаются с заголовка снимка
+func IsSnapshot(header []byte) bool {
+	return bytes.HasPrefix(header, []byte(snapshotMagic))
+}
+
+// Запись снимка блоклиста. Исходные записи сохраняются, если они есть в блоклисте.
+func (b *Blocklist) WriteSnapshot(w io.Writer) error {
+	prefixes := make([]Prefix, 0, b.prefixCount())
+	b.eachPrefix(func(p Prefix) {
+		prefixes = append(prefixes, p)
+	})
+	records, err := b.Records()
+	if err != nil {
+		return err
+	}
+	return writeSnapshot(w, prefixes, records, b.updated, b.domains)
+}
+
+// Сохранение снимка блоклиста в файл
+func (b *Blocklist) SaveSnapshot(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := b.WriteSnapshot(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Запись снимка дерева подсетей: сохраняются листья дерева
+func (t *IPTreeNode) WriteSnapshot(w io.Writer) error {
+	leaves := t.Leaves()
+	prefixes := make([]Prefix, 0, len(leaves))
+	for _, leaf := range leaves {
+		prefixes = append(prefixes, leaf.Prefix())
+	}
+	return writeSnapshot(w, prefixes, nil, "", nil)
+}
+
+func writeSnapshot(w io.Writer, prefixes []Prefix, records *RecordIndex, updated string, domains []string) error {
+	sortPrefixes(prefixes)
+
+	crc := crc32.NewIEEE()
+	bw := bufio.NewWriter(io.MultiWriter(w, crc))
+	sw := &snapshotWriter{w: bw}
+
+	var flags uint16
+	if records != nil {
+		flags |= snapshotRecords
+	}
+	if updated != "" {
+		flags |= snapshotUpdated
+	}
+	if domains != nil {
+		flags |= snapshotDomains
+	}
+	sw.w.WriteString(snapshotMagic)
+	sw.uint16(snapshotVersion)
+	sw.uint16(flags)
+	if updated != "" {
+		sw.string(updated)
+	}
+	if domains != nil {
+		sw.uint32(uint32(len(domains)))
+		for _, d := range domains {
+			sw.string(d)
+		}
+	}
+
+	sw.uint32(uint32(len(prefixes)))
+	for _, p := range prefixes {
+		sw.prefix(p)
+	}
+
+	if records != nil {
+		// раздел записей пишется после своего размера
+		var section bytes.Buffer
+		writeSnapshotRecords(&snapshotWriter{w: &section}, records)
+		sw.uint32(uint32(section.Len()))
+		sw.w.Write(section.Bytes())
+	}
+
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	var sum [4]byte
+	binary.BigEndian.PutUint32(sum[:], crc.Sum32())
+	_, err := w.Write(sum[:])
+	return err
+}
+
+// Запись раздела исходных записей
+func writeSnapshotRecords(sw *snapshotWriter, records *RecordIndex) {
+	// записи нумеруются в порядке строк блоклиста, ссылки - в порядке префиксов
+	refPrefixes := make([]Prefix, 0, len(records.records))
+	index := make(map[*Record]uint32)
+	var recs []*Record
+	for p, rr := range records.records {
+		refPrefixes = append(refPrefixes, p)
+		for _, r := range rr {
+			if _, ok := index[r]; !ok {
+				index[r] = 0
+				recs = append(recs, r)
+			}
+		}
+	}
+	recs = sortRecords(recs)
+	for i, r := range recs {
+		index[r] = uint32(i)
+	}
+	sortPrefixes(refPrefixes)
+
+	sw.uint32(uint32(len(recs)))
+	for _, r := range recs {
+		sw.uint32(uint32(r.Line))
+		for _, s := range []string{r.IPs, r.Domain, r.URL, r.Org, r.Decision, r.Date} {
+			sw.string(s)
+		}
+	}
+
+	refs := 0
+	for _, rr := range records.records {
+		refs += len(rr)
+	}
+	sw.uint32(uint32(refs))
+	for _, p := range refPrefixes {
+		for _, r := range records.records[p] {
+			sw.prefix(p)
+			sw.uint32(index[r])
+		}
+	}
+}
+
+// Последовательная запись полей снимка. Ошибки записи сохраняются в буфере w (bufio.Writer) до Flush.
+type snapshotWriter struct {
+	w interface {
+		io.Writer
+		io.ByteWriter
+		io.StringWriter
+	}
+	buf [binary.MaxVarintLen64]byte
+}
+
+func (sw *snapshotWriter) uint16(v uint16) {
+	binary.BigEndian.PutUint16(sw.buf[:2], v)
+	sw.w.Write(sw.buf[:2])
+}
+
+func (sw *snapshotWriter) uint32(v uint32) {
+	binary.BigEndian.PutUint32(sw.buf[:4], v)
+	sw.w.Write(sw.buf[:4])
+}
+
+func (sw *snapshotWriter) prefix(p Prefix) {
+	sw.uint32(p.Addr)
+	sw.w.WriteByte(p.Len)
+}
+
+func (sw *snapshotWriter) string(s string) {
+	sw.w.Write(sw.buf[:binary.PutUvarint(sw.buf[:], uint64(len(s)))])
+	sw.w.WriteString(s)
+}
+
+// Упорядочивание префиксов по адресу, затем по длине маски
+func sortPrefixes(prefixes []Prefix) {
+	sort.Slice(prefixes, func(i, j int) bool {
+		if prefixes[i].Addr != prefixes[j].Addr {
+			return prefixes[i].Addr < prefixes[j].Addr
+		}
+		return prefixes[i].Len < prefixes[j].Len
+	})
+}
+
+// Загрузка блоклиста из снимка
+func ReadSnapshot(r io.Reader) (*Blocklist, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < len(snapshotMagic)+4+4+4 || !IsSnapshot(data) {
+		return nil, errors.New("not a blocklist snapshot")
+	}
+	body, sum := data[:len(data)-4], binary.BigEndian.Uint32(data[len(data)-4:])
+	if crc32.ChecksumIEEE(body) != sum {
+		return nil, errors.New("snapshot checksum mismatch")
+	}
+
+	sr := &snapshotReader{data: body, pos: len(snapshotMagic)}
+	// снимки версии 1 отличаются только отсутствием новых флагов, версии 2 - размера раздела записей
+	version := sr.uint16()
+	if version < 1 || version > snapshotVersion {
+		return nil, fmt.Errorf("unsupported snapshot version %d", version)
+	}
+	flags := sr.uint16()
+	if flags&^snapshotKnownFlags != 0 {
+		return nil, fmt.Errorf("unsupported snapshot flags %#x", flags&^snapshotKnownFlags)
+	}
+
+	b := NewBlocklist()
+	if flags&snapshotUpdated != 0 {
+		b.updated = sr.string()
+	}
+	if flags&snapshotDomains != 0 {
+		count := sr.uint32()
+		if uint64(count) > uint64(len(body)) {
+			return nil, errors.New("snapshot is truncated")
+		}
+		b.domains = make([]string, 0, count)
+		for i := uint32(0); i < count && sr.err == nil; i++ {
+			b.domains = append(b.domains, sr.string())
+		}
+	}
+	count := sr.uint32()
+	if uint64(count)*5 > uint64(len(body)) {
+		return nil, errors.New("snapshot is truncated")
+	}
+	// префиксы записаны по возрастанию и загружаются без промежуточных net.IPNet и множества адресов
+	b.prefixList = make([]Prefix, 0, count)
+	for i := uint32(0); i < count && sr.err == nil; i++ {
+		b.prefixList = append(b.prefixList, sr.prefix())
+	}
+
+	if flags&snapshotRecords != 0 {
+		if version < 3 {
+			// без размера раздела записи разбираются сразу, чтобы проверить конец снимка
+			if b.records, err = readSnapshotRecords(sr); err != nil {
+				return nil, err
+			}
+		} else {
+			// раздел записей разбирается при первом обращении к Records (lookup, explain)
+			size := sr.uint32()
+			b.recordsData = &snapshotReader{data: sr.next(int(size))}
+		}
+	}
+
+	if sr.err != nil {
+		return nil, sr.err
+	}
+	if sr.pos != len(body) {
+		return nil, errors.New("unexpected data at the end of snapshot")
+	}
+	return b, nil
+}
+
+// Разбор раздела исходных записей снимка
+func readSnapshotRecords(sr *snapshotReader) (*RecordIndex, error) {
+	records := NewRecordIndex()
+	count := sr.uint32()
+	if uint64(count)*4 > uint64(len(sr.data)) {
+		return nil, errors.New("snapshot is truncated")
+	}
+	recs := make([]Record, count)
+	for i := range recs {
+		if sr.err != nil {
+			break
+		}
+		recs[i] = Record{
+			Line:     int(sr.uint32()),
+			IPs:      sr.string(),
+			Domain:   sr.string(),
+			URL:      sr.string(),
+			Org:      sr.string(),
+			Decision: sr.string(),
+			Date:     sr.string(),
+		}
+	}
+	refs := sr.uint32()
+	for i := uint32(0); i < refs && sr.err == nil; i++ {
+		p := sr.prefix()
+		idx := sr.uint32()
+		if idx >= uint32(len(recs)) {
+			return nil, errors.New("snapshot record reference out of range")
+		}
+		records.Add(p, &recs[idx])
+	}
+	if sr.err != nil {
+		return nil, sr.err
+	}
+	return records, nil
+}
+
+// Последовательное чтение полей снимка. Первая ошибка сохраняется в err, последующие чтения возвращают нули.
+type snapshotReader struct {
+	data []byte
+	pos  int
+	err  error
+}
+
+func (sr *snapshotReader) next(n int) []byte {
+	if sr.err != nil || len(sr.data)-sr.pos < n {
+		if sr.err == nil {
+			sr.err = errors.New("snapshot is truncated")
+		}
+		return make([]byte, n)
+	}
+	b := sr.data[sr.pos : sr.pos+n]
+	sr.pos += n
+	return b
+}
+
+func (sr *snapshotReader) uint16() uint16 {
+	return binary.BigEndian.Uint16(sr.next(2))
+}
+
+func (sr *snapshotReader) uint32() uint32 {
+	return binary.BigEndian.Uint32(sr.next(4))
+}
+
+func (sr *snapshotReader) prefix() Prefix {
+	addr := sr.uint32()
+	length := sr.next(1)[0]
+	if length > 32 {
+		if sr.err == nil {
+			sr.err = errors.New("invalid prefix length in snapshot")
+		}
+		return Prefix{}
+	}
+	return NewPrefix(addr, length)
+}
+
+func (sr *snapshotReader) string() string {
+	if sr.err != nil {
+		return ""
+	}
+	n, m := binary.Uvarint(sr.data[sr.pos:])
+	if m <= 0 || n > uint64(len(sr.data)) {
+		sr.err = errors.New("snapshot is truncated")
+		return ""
+	}
+	sr.pos += m
+	return string(sr.next(int(n)))
+}
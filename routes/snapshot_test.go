@@ -0,0 +1,143 @@
+package routes
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Синтетический дамп z-i из lines строк: отдельные адреса и подсети /24 с доменами
+func testDump(lines int) string {
+	rnd := rand.New(rand.NewSource(1))
+	var sb strings.Builder
+	sb.WriteString("Updated: 2026-10-19 10:00:00 +0000\n")
+	for i := 0; i < lines; i++ {
+		for j, n := 0, 1+rnd.Intn(3); j < n; j++ {
+			if j > 0 {
+				sb.WriteString(" | ")
+			}
+			a, b, c := 1+rnd.Intn(223), rnd.Intn(256), rnd.Intn(256)
+			if rnd.Intn(20) == 0 {
+				fmt.Fprintf(&sb, "%d.%d.%d.0/24", a, b, c)
+			} else {
+				fmt.Fprintf(&sb, "%d.%d.%d.%d", a, b, c, rnd.Intn(256))
+			}
+		}
+		fmt.Fprintf(&sb, ";site%d.example.com;https://site%d.example.com/;Org;27-31-2018/Id%d-18;2018-04-16\n", i, i, i)
+	}
+	return sb.String()
+}
+
+func parseTestDump(t testing.TB, dump string, keepRecords bool) *Blocklist {
+	b := NewBlocklist()
+	b.SetParser(&ZapretInfoParser{AllowEmptyDomain: true, KeepRecords: keepRecords, KeepDomains: true})
+	if err := b.Parse(strings.NewReader(dump)); err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func snapshotOf(t testing.TB, b *Blocklist) []byte {
+	var buf bytes.Buffer
+	if err := b.WriteSnapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func sortedPrefixes(b *Blocklist) []Prefix {
+	var prefixes []Prefix
+	b.eachPrefix(func(p Prefix) {
+		prefixes = append(prefixes, p)
+	})
+	sortPrefixes(prefixes)
+	return prefixes
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	for _, keepRecords := range []bool{false, true} {
+		b := parseTestDump(t, testDump(2000), keepRecords)
+		loaded := NewBlocklist()
+		if err := loaded.Parse(bytes.NewReader(snapshotOf(t, b))); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(sortedPrefixes(loaded), sortedPrefixes(b)) {
+			t.Errorf("records %v: prefixes differ", keepRecords)
+		}
+		if loaded.Updated() != b.Updated() || !reflect.DeepEqual(loaded.Domains(), b.Domains()) {
+			t.Errorf("records %v: updated or domains differ", keepRecords)
+		}
+		if loaded.SubnetsTree().SubtreeSize != b.SubnetsTree().SubtreeSize {
+			t.Errorf("records %v: tree sizes differ", keepRecords)
+		}
+
+		// записи разбираются при первом обращении
+		if keepRecords && loaded.records != nil {
+			t.Errorf("records are decoded eagerly")
+		}
+		want, _ := b.Records()
+		got, err := loaded.Records()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (got == nil) != (want == nil) {
+			t.Fatalf("records %v: got records %v", keepRecords, got != nil)
+		}
+		if want == nil {
+			continue
+		}
+		for _, p := range sortedPrefixes(b)[:100] {
+			if !reflect.DeepEqual(got.Lookup(p.Addr), want.Lookup(p.Addr)) {
+				t.Errorf("records of %s differ", p)
+			}
+		}
+	}
+}
+
+func TestSnapshotCorrupted(t *testing.T) {
+	data := snapshotOf(t, parseTestDump(t, testDump(100), true))
+	for name, d := range map[string][]byte{
+		"truncated": data[:len(data)-10],
+		"checksum":  append(append([]byte{}, data[:len(data)-1]...), data[len(data)-1]^1),
+	} {
+		if _, err := ReadSnapshot(bytes.NewReader(d)); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
+
+// Сравнение загрузки снимка с разбором CSV того же блоклиста
+func BenchmarkLoadCSV(b *testing.B) {
+	dump := testDump(100000)
+	b.SetBytes(int64(len(dump)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		parseTestDump(b, dump, false)
+	}
+}
+
+func BenchmarkLoadSnapshot(b *testing.B) {
+	data := snapshotOf(b, parseTestDump(b, testDump(100000), false))
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := ReadSnapshot(bytes.NewReader(data)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// Снимок с исходными записями: записи не разбираются, пока не нужны
+func BenchmarkLoadSnapshotRecords(b *testing.B) {
+	data := snapshotOf(b, parseTestDump(b, testDump(100000), true))
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := ReadSnapshot(bytes.NewReader(data)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -1,4 +1,4 @@
-package main
+package routes
 
 import (
 	"net"
@@ -57,9 +57,13 @@ func (t *IPTreeNode) addIP(ip ipv4range.IPv4, depth uint8) (success bool) {
 
 	if *child == nil {
 		*child = NewIPTreeNode(ip, depth, t)
+	} else if depth == 32 {
+		// Добавляемый IP уже был добавлен
+		return false
 	} else {
 		(*child).SubtreeSize++
 		(*child).SubtreeLeafsCount++
+		(*child).penalty = 0
 	}
 
 	if depth < 32 {
@@ -68,6 +72,7 @@ func (t *IPTreeNode) addIP(ip ipv4range.IPv4, depth uint8) (success bool) {
 		} else {
 			(*child).SubtreeSize--
 			(*child).SubtreeLeafsCount--
+			(*child).penalty = 0
 			return false
 		}
 	}
@@ -75,7 +80,11 @@ func (t *IPTreeNode) addIP(ip ipv4range.IPv4, depth uint8) (success bool) {
 }
 
 func (t *IPTreeNode) AddIP(ip ipv4range.IPv4) {
-	t.addIP(ip, 1)
+	if t.addIP(ip, 1) {
+		t.SubtreeSize++
+		t.SubtreeLeafsCount++
+		t.penalty = 0
+	}
 }
 
 // Добавление подсети.
@@ -98,6 +107,9 @@ func (t *IPTreeNode) addSubnet(s *net.IPNet, depth uint8) (success bool, size ui
 
 	if *child == nil {
 		*child = NewIPTreeNode(ipv4range.IPv4(ip), depth, t)
+		// счетчики нового узла заполнятся при добавлении подсети
+		(*child).SubtreeSize = 0
+		(*child).SubtreeLeafsCount = 0
 	}
 
 	maskSize, _ := s.Mask.Size()
@@ -106,6 +118,7 @@ func (t *IPTreeNode) addSubnet(s *net.IPNet, depth uint8) (success bool, size ui
 		if success, size, count = (*child).addSubnet(s, depth+1); success {
 			t.SubtreeSize += size
 			t.SubtreeLeafsCount = uint32(int64(t.SubtreeLeafsCount) + count)
+			t.penalty = 0
 			return true, size, count
 		} else {
 			return false, 0, 0
@@ -119,18 +132,56 @@ func (t *IPTreeNode) addSubnet(s *net.IPNet, depth uint8) (success bool, size ui
 		t.IsLeaf = true
 		// размер добавленного поддерева считаем как вместимость поддерева за вычетом уже бывших здесь подсетей
 		size = t.SubtreeCapacity - t.SubtreeSize
-		count = int64(1 - t.SubtreeLeafsCount)
+		count = 1 - int64(t.SubtreeLeafsCount)
 		t.SubtreeSize = t.SubtreeCapacity
 		t.SubtreeLeafsCount = 1
+		t.penalty = 0
 		return true, size, count
 	}
 	return true, t.SubtreeCapacity, int64(t.SubtreeLeafsCount)
 }
 
 func (t *IPTreeNode) AddSubnet(s *net.IPNet) {
+	if ones, _ := s.Mask.Size(); ones == 32 {
+		// подсеть из одного адреса добавляем как отдельный IP
+		t.AddIP(ipv4range.IPv4(binary.BigEndian.Uint32(s.IP.To4())))
+		return
+	}
 	t.addSubnet(s, 1)
 }
 
+// Присоединение поддерева n, построенного отдельно, на его место в дереве t.
+// Место должно быть свободно. Недостающие промежуточные узлы создаются, счетчики предков увеличиваются на счетчики n.
+func (t *IPTreeNode) graft(n *IPTreeNode) {
+	addr := binary.BigEndian.Uint32(n.Value.To4())
+	node := t
+	for {
+		var child **IPTreeNode
+		if addr&(1<<(31-node.MaskSize)) != 0 {
+			child = &node.One
+		} else {
+			child = &node.Zero
+		}
+		if node.MaskSize+1 == n.MaskSize {
+			*child = n
+			n.Parent = node
+			break
+		}
+		if *child == nil {
+			*child = NewIPTreeNode(ipv4range.IPv4(addr), node.MaskSize+1, node)
+			(*child).SubtreeSize = 0
+			(*child).SubtreeLeafsCount = 0
+		}
+		node = *child
+	}
+
+	for ; node != nil; node = node.Parent {
+		node.SubtreeSize += n.SubtreeSize
+		node.SubtreeLeafsCount += n.SubtreeLeafsCount
+		node.penalty = 0
+	}
+}
+
 // Удаление поддерева
 func (t *IPTreeNode) DeleteSubtree() {
 	if t.One != nil {
@@ -172,8 +223,11 @@ func (t *IPTreeNode) Fallthrough() (leaf *IPTreeNode) {
 	}
 }
 
-func (t *IPTreeNode) excludeSubnet(s *net.IPNet, depth uint8) (excludedSize uint32, excludedCount int64) {
+// Удаление подсети. При force все узлы на пути к удаляемой подсети должны быть обязательно разбиты при оптимизации.
+// Возвращает кол-во удаленных адресов и уменьшение кол-ва листьев поддерева.
+func (t *IPTreeNode) excludeSubnet(s *net.IPNet, depth uint8, force bool) (excludedSize uint32, excludedCount int64) {
 	var child **IPTreeNode
+	var splitCount int64
 
 	ip := binary.BigEndian.Uint32(s.IP)
 	maskedIP := binary.BigEndian.Uint32(s.IP.Mask(net.CIDRMask(int(depth-1), 32)).To4()[:])
@@ -193,7 +247,9 @@ func (t *IPTreeNode) excludeSubnet(s *net.IPNet, depth uint8) (excludedSize uint
 		t.Zero.SubtreeSize = t.Zero.SubtreeCapacity
 		t.Zero.IsLeaf = true
 		t.IsLeaf = false
-		excludedCount--
+		// лист разделился на два
+		t.SubtreeLeafsCount = 2
+		splitCount = -1
 	} else if *child == nil {
 		// Выходим, так как исключаемая подсеть отсутствует
 		return 0, 0
@@ -201,27 +257,102 @@ func (t *IPTreeNode) excludeSubnet(s *net.IPNet, depth uint8) (excludedSize uint
 
 	maskSize, _ := s.Mask.Size()
 	if int(depth) < maskSize {
-		t.ForceExpand = true
-		excludedSize, excludedCount = (*child).excludeSubnet(s, depth+1)
-		t.SubtreeSize -= excludedSize
-		t.SubtreeLeafsCount = uint32(int64(t.SubtreeLeafsCount) - excludedCount + 1)
+		if force {
+			t.ForceExpand = true
+		}
+		excludedSize, excludedCount = (*child).excludeSubnet(s, depth+1, force)
 		if (*child).SubtreeSize == 0 {
 			(*child).DeleteSubtree()
 			*child = nil
 		}
 	} else {
 		excludedSize = (*child).SubtreeSize
-		excludedCount += int64((*child).SubtreeLeafsCount)
-		t.SubtreeSize -= excludedSize
-		t.SubtreeLeafsCount = uint32(int64(t.SubtreeLeafsCount) - excludedCount)
+		excludedCount = int64((*child).SubtreeLeafsCount)
 		(*child).DeleteSubtree()
 		*child = nil
 	}
+	t.SubtreeSize -= excludedSize
+	t.SubtreeLeafsCount = uint32(int64(t.SubtreeLeafsCount) - excludedCount)
+	t.penalty = 0
+	// для родителя изменение считается относительно исходного кол-ва листьев
+	excludedCount += splitCount
 	return
 }
 
+// Исключение подсети из результата: подсеть удаляется, а содержащие её подсети не могут попасть в маршруты
 func (t *IPTreeNode) ExcludeSubnet(s *net.IPNet) {
-	t.excludeSubnet(s, 1)
+	t.excludeSubnet(s, 1, true)
+}
+
+// Удаление подсети, например разблокированной. Оставшиеся адреса подсетей, в которые она входила, сохраняются.
+func (t *IPTreeNode) RemoveSubnet(s *net.IPNet) {
+	t.excludeSubnet(s, 1, false)
+}
+
+// Удаление IP-адреса
+func (t *IPTreeNode) RemoveIP(ip ipv4range.IPv4) {
+	ipBuf := make(net.IP, 4)
+	binary.BigEndian.PutUint32(ipBuf, uint32(ip))
+	t.RemoveSubnet(&net.IPNet{IP: ipBuf, Mask: net.CIDRMask(32, 32)})
+}
+
+// Применение изменений блоклиста: сначала удаляются подсети removed, затем добавляются added.
+// Счетчики поддеревьев и штрафы остаются согласованными, поэтому дерево не нужно строить заново.
+func (t *IPTreeNode) ApplyDiff(added, removed []*net.IPNet) {
+	for _, n := range removed {
+		t.RemoveSubnet(n)
+	}
+	for _, n := range added {
+		t.AddSubnet(n)
+	}
+}
+
+// Копирование дерева. Оптимизация изменяет дерево (исключает подсети), поэтому при отслеживании изменений
+// блоклиста оптимизировать следует копию.
+func (t *IPTreeNode) Clone() *IPTreeNode {
+	return t.clone(nil)
+}
+
+func (t *IPTreeNode) clone(parent *IPTreeNode) *IPTreeNode {
+	if t == nil {
+		return nil
+	}
+	c := *t
+	c.Parent = parent
+	c.Zero = t.Zero.clone(&c)
+	c.One = t.One.clone(&c)
+	return &c
+}
+
+// Поиск самого глубокого узла, подсеть которого содержит ip. Если ip заблокирован, это будет содержащий его лист.
+func (t *IPTreeNode) LongestMatch(ip ipv4range.IPv4) *IPTreeNode {
+	node := t
+	for !node.IsLeaf {
+		var child *IPTreeNode
+		if ip&(1<<(31-node.MaskSize)) != 0 {
+			child = node.One
+		} else {
+			child = node.Zero
+		}
+		if child == nil {
+			break
+		}
+		node = child
+	}
+	return node
+}
+
+// Проверка, входит ли ip в заблокированные адреса или подсети
+func (t *IPTreeNode) Contains(ip ipv4range.IPv4) bool {
+	return t.LongestMatch(ip).IsLeaf
+}
+
+// Префикс подсети узла
+func (t *IPTreeNode) Prefix() Prefix {
+	if len(t.Value) != net.IPv4len {
+		return Prefix{}
+	}
+	return NewPrefix(binary.BigEndian.Uint32(t.Value), t.MaskSize)
 }
 
 func (t *IPTreeNode) Network() *net.IPNet {
@@ -252,3 +383,57 @@ func (t *IPTreeNode) Sibling() (*IPTreeNode) {
 		return t.Parent.One
 	}
 }
+
+// Подсеть потомка с указанным битом
+func (t *IPTreeNode) childNetwork(bit uint32) *net.IPNet {
+	var ip uint32
+	if len(t.Value) == net.IPv4len {
+		ip = binary.BigEndian.Uint32(t.Value)
+	}
+	ip |= bit << (31 - t.MaskSize)
+
+	ipBuf := make(net.IP, 4)
+	binary.BigEndian.PutUint32(ipBuf, ip)
+	return &net.IPNet{IP: ipBuf, Mask: net.CIDRMask(int(t.MaskSize)+1, 32)}
+}
+
+// Получение незаполненных подсетей поддерева, т.е. подсетей отсутствующих потомков
+func (t *IPTreeNode) Gaps() (gaps []*net.IPNet) {
+	if t.IsLeaf {
+		return nil
+	}
+	for bit, child := range []*IPTreeNode{t.Zero, t.One} {
+		if child == nil {
+			gaps = append(gaps, t.childNetwork(uint32(bit)))
+		} else {
+			gaps = append(gaps, child.Gaps()...)
+		}
+	}
+	return
+}
+
+// Кол-во незаполненных подсетей поддерева
+func (t *IPTreeNode) GapsCount() (count uint) {
+	if t.IsLeaf {
+		return 0
+	}
+	for _, child := range []*IPTreeNode{t.Zero, t.One} {
+		if child == nil {
+			count++
+		} else {
+			count += child.GapsCount()
+		}
+	}
+	return
+}
+
+// Листья поддерева, т.е. заблокированные адреса и подсети, в порядке возрастания адресов
+func (t *IPTreeNode) Leaves() (leaves []*IPTreeNode) {
+	if t == nil {
+		return nil
+	}
+	if t.IsLeaf {
+		return []*IPTreeNode{t}
+	}
+	return append(t.Zero.Leaves(), t.One.Leaves()...)
+}
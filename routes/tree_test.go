@@ -0,0 +1,197 @@
+package routes
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/amkulikov/ipv4range"
+)
+
+// Дерево, построенное добавлением подсетей в CIDR по порядку
+func testTree(t *testing.T, cidrs ...string) *IPTreeNode {
+	root := &IPTreeNode{}
+	for _, n := range mustCIDRs(t, cidrs...) {
+		root.AddSubnet(n)
+	}
+	return root
+}
+
+// Блоклист из подсетей в CIDR, по одной на строку
+func testBlocklist(t *testing.T, cidrs ...string) *Blocklist {
+	var sb strings.Builder
+	for i, c := range cidrs {
+		fmt.Fprintf(&sb, "%s;site%d.example.com;;;;\n", c, i)
+	}
+	return parseTestDump(t, sb.String(), false)
+}
+
+// Сравнение структуры, счетчиков и штрафов деревьев. Возвращает описание первого отличия.
+func diffTrees(got, want *IPTreeNode) string {
+	if got == nil || want == nil {
+		if got != want {
+			return fmt.Sprintf("node presence differs: got %v, want %v", got != nil, want != nil)
+		}
+		return ""
+	}
+	name := want.Prefix().String()
+	switch {
+	case got.Prefix() != want.Prefix():
+		return fmt.Sprintf("%s: got node %s", name, got.Prefix())
+	case got.IsLeaf != want.IsLeaf:
+		return fmt.Sprintf("%s: got leaf %v", name, got.IsLeaf)
+	case got.SubtreeSize != want.SubtreeSize:
+		return fmt.Sprintf("%s: got size %d, want %d", name, got.SubtreeSize, want.SubtreeSize)
+	case got.SubtreeLeafsCount != want.SubtreeLeafsCount:
+		return fmt.Sprintf("%s: got leafs %d, want %d", name, got.SubtreeLeafsCount, want.SubtreeLeafsCount)
+	case got.SubtreeCapacity != want.SubtreeCapacity:
+		return fmt.Sprintf("%s: got capacity %d, want %d", name, got.SubtreeCapacity, want.SubtreeCapacity)
+	case got.ForceExpand != want.ForceExpand:
+		return fmt.Sprintf("%s: got force %v", name, got.ForceExpand)
+	}
+	// штраф пустого поддерева не определен; сохраненный штраф должен совпадать с рассчитанным заново
+	if want.SubtreeSize > 0 && got.Penalty() != want.Penalty() {
+		return fmt.Sprintf("%s: got penalty %d, want %d", name, got.Penalty(), want.Penalty())
+	}
+	for _, child := range []*IPTreeNode{got.Zero, got.One} {
+		if child != nil && child.Parent != got {
+			return fmt.Sprintf("%s: wrong parent of %s", name, child.Prefix())
+		}
+	}
+	if d := diffTrees(got.Zero, want.Zero); d != "" {
+		return d
+	}
+	return diffTrees(got.One, want.One)
+}
+
+func TestIPTreeNodeRemove(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		tree   []string
+		remove []string // адреса без маски удаляются RemoveIP
+		want   []string
+	}{
+		{
+			name:   "ip",
+			tree:   []string{"1.2.3.4/32", "1.2.3.5/32", "10.0.0.0/8"},
+			remove: []string{"1.2.3.4"},
+			want:   []string{"1.2.3.5/32", "10.0.0.0/8"},
+		},
+		{
+			name:   "subnet",
+			tree:   []string{"10.0.0.0/8", "11.0.0.0/16", "11.1.0.0/16"},
+			remove: []string{"11.0.0.0/16"},
+			want:   []string{"10.0.0.0/8", "11.1.0.0/16"},
+		},
+		{
+			name:   "missing",
+			tree:   []string{"10.0.0.0/8", "1.2.3.4/32"},
+			remove: []string{"12.0.0.0/8", "1.2.3.5", "10.0.0.0/7"},
+			want:   []string{"1.2.3.4/32"},
+		},
+		{
+			name:   "parent of existing subnet",
+			tree:   []string{"10.1.0.0/16", "10.2.3.4/32", "10.200.0.0/24", "11.0.0.0/8"},
+			remove: []string{"10.0.0.0/8"},
+			want:   []string{"11.0.0.0/8"},
+		},
+		{
+			name:   "parent of single address",
+			tree:   []string{"1.2.3.4/32", "1.2.4.0/24"},
+			remove: []string{"1.2.3.0/24"},
+			want:   []string{"1.2.4.0/24"},
+		},
+		{
+			name:   "address of subnet",
+			tree:   []string{"10.0.0.0/24"},
+			remove: []string{"10.0.0.5"},
+			want: []string{"10.0.0.0/30", "10.0.0.4/32", "10.0.0.6/31", "10.0.0.8/29",
+				"10.0.0.16/28", "10.0.0.32/27", "10.0.0.64/26", "10.0.0.128/25"},
+		},
+		{
+			name:   "half of subnet",
+			tree:   []string{"10.0.0.0/8", "12.0.0.1/32"},
+			remove: []string{"10.128.0.0/9"},
+			want:   []string{"10.0.0.0/9", "12.0.0.1/32"},
+		},
+		{
+			name:   "split then remove rest",
+			tree:   []string{"10.0.0.0/30"},
+			remove: []string{"10.0.0.1", "10.0.0.0", "10.0.0.2/31"},
+			want:   nil,
+		},
+		{
+			name:   "last address",
+			tree:   []string{"1.2.3.4/32"},
+			remove: []string{"1.2.3.4"},
+			want:   nil,
+		},
+		{
+			name:   "empty tree",
+			tree:   nil,
+			remove: []string{"10.0.0.0/8", "1.1.1.1", "128.0.0.0/1"},
+			want:   nil,
+		},
+	} {
+		tree := testTree(t, tc.tree...)
+		for _, r := range tc.remove {
+			if strings.Contains(r, "/") {
+				tree.RemoveSubnet(mustCIDRs(t, r)[0])
+			} else {
+				tree.RemoveIP(ipv4range.IPv4(PrefixOf(mustCIDRs(t, r+"/32")[0]).Addr))
+			}
+			// дерево должно оставаться согласованным после каждого удаления
+			if tree.SubtreeSize > 0 {
+				tree.Penalty()
+			}
+		}
+		if d := diffTrees(tree, testTree(t, tc.want...)); d != "" {
+			t.Errorf("%s: %s", tc.name, d)
+		}
+	}
+}
+
+func TestIPTreeNodeApplyDiff(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		old, new []string
+	}{
+		{
+			name: "added and removed addresses",
+			old:  []string{"1.2.3.4/32", "1.2.3.5/32", "5.6.7.8/32"},
+			new:  []string{"1.2.3.4/32", "5.6.7.9/32", "9.9.9.9/32"},
+		},
+		{
+			name: "removed parent of remaining subnet",
+			old:  []string{"10.0.0.0/8", "10.1.0.0/16", "10.2.3.4/32"},
+			new:  []string{"10.1.0.0/16", "10.2.3.4/32"},
+		},
+		{
+			name: "removed address of remaining subnet",
+			old:  []string{"10.0.0.0/24", "10.0.0.5/32"},
+			new:  []string{"10.0.0.0/24"},
+		},
+		{
+			name: "subnet replaces addresses",
+			old:  []string{"10.0.0.1/32", "10.0.0.2/32"},
+			new:  []string{"10.0.0.0/24"},
+		},
+		{
+			name: "from empty",
+			old:  nil,
+			new:  []string{"10.0.0.0/8", "1.2.3.4/32"},
+		},
+		{
+			name: "to empty",
+			old:  []string{"10.0.0.0/8", "1.2.3.4/32"},
+			new:  nil,
+		},
+	} {
+		old, updated := testBlocklist(t, tc.old...), testBlocklist(t, tc.new...)
+		tree := old.SubnetsTree()
+		tree.ApplyDiff(old.Diff(updated))
+		if d := diffTrees(tree, updated.SubnetsTree()); d != "" {
+			t.Errorf("%s: %s", tc.name, d)
+		}
+	}
+}